@@ -3,14 +3,17 @@ module miku_bot
 go 1.25.4
 
 require (
-	github.com/bwmarrin/discordgo v0.29.0 // indirect
-	github.com/dhowden/tag v0.0.0-20240417053706-3d75831295e8 // indirect
+	github.com/bwmarrin/discordgo v0.29.0
+	github.com/dhowden/tag v0.0.0-20240417053706-3d75831295e8
+	github.com/joho/godotenv v1.5.1
+	github.com/jonas747/dca v0.0.0-20210930103944-155f5e5f0cc7
+	github.com/mattn/go-sqlite3 v1.14.32
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/jonas747/dca v0.0.0-20210930103944-155f5e5f0cc7 // indirect
 	github.com/jonas747/ogg v0.0.0-20161220051205-b4f6f4cf3757 // indirect
-	github.com/mattn/go-sqlite3 v1.14.32 // indirect
 	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
 	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
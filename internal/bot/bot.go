@@ -51,6 +51,7 @@ type Bot struct {
 	DB       *database.Database
 	QueueMgr *queue.Manager
 	Commands *commands.Handler
+	Library  *music.Libraries
 }
 
 func New(token string, configPath string) (*Bot, error) {
@@ -69,21 +70,51 @@ func New(token string, configPath string) (*Bot, error) {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	queueMgr := queue.NewManager(db)
+	hosts, err := music.NewHostFilter(config.HostPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("invalid sources.hosts: %w", err)
+	}
 
-	// Initialize local music library if configured
-	var library *music.Library
-	if config.Music.MusicFolder != "" && config.Sources.Local {
-		library, err = music.NewLibrary(config.Music.MusicFolder)
+	// Initialize local music libraries if configured
+	var libraries *music.Libraries
+	if config.Sources.Local && len(config.LibraryRoots()) > 0 {
+		art, err := music.NewArtCache(config.ArtCacheDir(), db, config.Music.ArtCache.MaxSizeMB, config.Music.ArtCache.MaxDimension)
 		if err != nil {
-			log.Printf("Warning: Failed to initialize local music library: %v", err)
-			log.Println("Local file playback will be disabled")
+			return nil, err
+		}
+
+		libraries = music.NewLibraries()
+		for _, root := range config.LibraryRoots() {
+			library, err := music.NewLibrary(root.Name, root.Path, db, art)
+			if err != nil {
+				log.Printf("Warning: Failed to initialize local music library %q: %v", root.Name, err)
+				log.Printf("Local files from %q will be unavailable", root.Name)
+				continue
+			}
+			log.Printf("Local music library %q initialized: %d files found", root.Name, library.GetTotalFiles())
+			libraries.Add(library, root.Guilds)
+		}
+
+		if len(libraries.All()) == 0 {
+			libraries = nil
 		} else {
-			log.Printf("Local music library initialized: %d files found", library.GetTotalFiles())
+			libraries.Watch()
 		}
 	}
 
-	commandHandler := commands.NewHandler(db, queueMgr, config.Bot.Prefix, library)
+	resolvers := music.NewResolvers(hosts, config.Resolvers(hosts, libraries)...)
+	queueMgr := queue.NewManager(db, config.Music.MaxQueueSize, resolvers)
+
+	attachments, err := music.NewAttachments(hosts, config.Music.Attachments.MaxSizeMB, config.Music.Attachments.CacheDir, config.Music.Attachments.CacheMaxSizeMB)
+	if err != nil {
+		return nil, err
+	}
+
+	commandHandler := commands.NewHandler(db, queueMgr, config.Bot.Prefix, libraries, attachments, resolvers, hosts, config.EnabledSources(), config.Bot.MemberEvents)
+	resolvers.Denied = func(guildID string, track *music.Track, err error) {
+		commandHandler.AuditDenied(session, guildID, track, err)
+	}
+	queueMgr.Speaking = commandHandler.HandleSpeaking
 
 	bot := &Bot{
 		Session:  session,
@@ -91,16 +122,27 @@ func New(token string, configPath string) (*Bot, error) {
 		DB:       db,
 		QueueMgr: queueMgr,
 		Commands: commandHandler,
+		Library:  libraries,
 	}
 
 	session.AddHandler(bot.ready)
 	session.AddHandler(commandHandler.HandleMessage)
+	session.AddHandler(commandHandler.HandleMemberUpdate)
+	session.AddHandler(commandHandler.HandleRoleUpdate)
+	session.AddHandler(commandHandler.HandleRoleDelete)
+	session.AddHandler(commandHandler.HandleVoiceStateUpdate)
 
 	session.Identify.Intents = discordgo.IntentsGuilds |
 		discordgo.IntentsGuildMessages |
 		discordgo.IntentsGuildVoiceStates |
 		discordgo.IntentsMessageContent
 
+	// Member updates are a privileged intent; without it cached permission
+	// levels only refresh when they expire.
+	if config.Bot.MemberEvents {
+		session.Identify.Intents |= discordgo.IntentsGuildMembers
+	}
+
 	return bot, nil
 }
 
@@ -131,6 +173,10 @@ func (b *Bot) Start() error {
 func (b *Bot) Stop() error {
 	log.Println("Shutting down...")
 
+	if b.Library != nil {
+		b.Library.Close()
+	}
+
 	if err := b.Session.Close(); err != nil {
 		return fmt.Errorf("failed to close session: %w", err)
 	}
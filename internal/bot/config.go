@@ -24,15 +24,19 @@ package bot
 import (
 	"fmt"
 	"os"
+	"strings"
+
+	"miku_bot/internal/music"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Bot struct {
-		Prefix   string `yaml:"prefix"`
-		Activity string `yaml:"activity"`
-		Status   string `yaml:"status"`
+		Prefix       string `yaml:"prefix"`
+		Activity     string `yaml:"activity"`
+		Status       string `yaml:"status"`
+		MemberEvents bool   `yaml:"member_events"`
 	} `yaml:"bot"`
 
 	Database struct {
@@ -45,10 +49,21 @@ type Config struct {
 	} `yaml:"roles"`
 
 	Music struct {
-		MaxQueueSize  int    `yaml:"max_queue_size"`
-		DefaultVolume int    `yaml:"default_volume"`
-		Timeout       int    `yaml:"timeout"`
-		MusicFolder   string `yaml:"music_folder"`
+		MaxQueueSize  int             `yaml:"max_queue_size"`
+		DefaultVolume int             `yaml:"default_volume"`
+		Timeout       int             `yaml:"timeout"`
+		MusicFolder   string          `yaml:"music_folder"`
+		Libraries     []LibraryConfig `yaml:"libraries"`
+		ArtCache      struct {
+			Dir          string `yaml:"dir"`
+			MaxSizeMB    int    `yaml:"max_size_mb"`
+			MaxDimension int    `yaml:"max_dimension"`
+		} `yaml:"art_cache"`
+		Attachments struct {
+			MaxSizeMB      int    `yaml:"max_size_mb"`
+			CacheDir       string `yaml:"cache_dir"`
+			CacheMaxSizeMB int    `yaml:"cache_max_size_mb"`
+		} `yaml:"attachments"`
 	} `yaml:"music"`
 
 	Sources struct {
@@ -59,9 +74,107 @@ type Config struct {
 		Twitch     bool `yaml:"twitch"`
 		Local      bool `yaml:"local"`
 		HTTP       bool `yaml:"http"`
+
+		// Hosts limits where online audio may come from
+		Hosts struct {
+			Allow        []string `yaml:"allow"`
+			Deny         []string `yaml:"deny"`
+			AllowPrivate bool     `yaml:"allow_private"`
+		} `yaml:"hosts"`
 	} `yaml:"sources"`
 }
 
+// LibraryConfig is a named local music root. Guilds lists the guild IDs
+// that can see it; leave it empty to share the root with every guild.
+type LibraryConfig struct {
+	Name   string   `yaml:"name"`
+	Path   string   `yaml:"path"`
+	Guilds []string `yaml:"guilds"`
+}
+
+// defaultLibraryName names the root configured through music_folder.
+const defaultLibraryName = "default"
+
+// defaultArtCacheDir is where album art is kept unless configured.
+const defaultArtCacheDir = "albumart"
+
+// ArtCacheDir returns the album art cache directory.
+func (c *Config) ArtCacheDir() string {
+	if c.Music.ArtCache.Dir != "" {
+		return c.Music.ArtCache.Dir
+	}
+	return defaultArtCacheDir
+}
+
+// LibraryRoots returns every configured library root: music_folder, shared
+// with all guilds, followed by the named libraries.
+func (c *Config) LibraryRoots() []LibraryConfig {
+	var roots []LibraryConfig
+	if c.Music.MusicFolder != "" {
+		roots = append(roots, LibraryConfig{Name: defaultLibraryName, Path: c.Music.MusicFolder})
+	}
+	return append(roots, c.Music.Libraries...)
+}
+
+// EnabledSources returns the sources switched on under sources.
+func (c *Config) EnabledSources() music.SourceSet {
+	return music.SourceSet{
+		music.SourceYouTube:    c.Sources.YouTube,
+		music.SourceSoundCloud: c.Sources.SoundCloud,
+		music.SourceBandcamp:   c.Sources.Bandcamp,
+		music.SourceVimeo:      c.Sources.Vimeo,
+		music.SourceTwitch:     c.Sources.Twitch,
+		music.SourceHTTP:       c.Sources.HTTP,
+		music.SourceLocal:      c.Sources.Local,
+	}
+}
+
+// HostPolicy returns the host allow and deny lists under sources.hosts.
+func (c *Config) HostPolicy() music.HostPolicy {
+	return music.HostPolicy{
+		Allow:        c.Sources.Hosts.Allow,
+		Deny:         c.Sources.Hosts.Deny,
+		AllowPrivate: c.Sources.Hosts.AllowPrivate,
+	}
+}
+
+// Resolvers returns the resolvers for the enabled sources, most specific
+// first: local paths, then radio streams and plain audio links, which would
+// otherwise fall through to yt-dlp. Local paths are confined to the roots
+// in libraries that the requesting guild can see, and everything online
+// to the hosts the host policy allows.
+func (c *Config) Resolvers(hosts *music.HostFilter, libraries *music.Libraries) []music.Resolver {
+	var resolvers []music.Resolver
+	if c.Sources.Local {
+		resolvers = append(resolvers, music.NewLocalResolver(libraries))
+	}
+	if c.Sources.HTTP {
+		resolvers = append(resolvers, music.NewRadioResolver(hosts), music.NewHTTPResolver(hosts))
+	}
+	if c.Sources.YouTube || c.Sources.SoundCloud || c.Sources.Bandcamp || c.Sources.Vimeo || c.Sources.Twitch || c.Sources.HTTP {
+		resolvers = append(resolvers, music.NewYTDLPResolver(hosts))
+	}
+	return resolvers
+}
+
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	for _, root := range c.LibraryRoots() {
+		switch {
+		case root.Name == "":
+			return fmt.Errorf("library with path %q has no name", root.Path)
+		case strings.ContainsAny(root.Name, ": "):
+			return fmt.Errorf("library name %q must not contain spaces or colons", root.Name)
+		case root.Path == "":
+			return fmt.Errorf("library %q has no path", root.Name)
+		case names[root.Name]:
+			return fmt.Errorf("duplicate library name %q", root.Name)
+		}
+		names[root.Name] = true
+	}
+	return nil
+}
+
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -73,5 +186,9 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return &config, nil
 }
@@ -0,0 +1,104 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// artURLTTL is how long the link to uploaded album art is reused. Discord's
+// attachment links expire after about a day.
+const artURLTTL = 12 * time.Hour
+
+// artURL is where a piece of album art was uploaded to Discord.
+type artURL struct {
+	url     string
+	expires time.Time
+}
+
+func (h *Handler) cachedArtURL(artPath string) string {
+	h.artMu.Lock()
+	defer h.artMu.Unlock()
+
+	cached, ok := h.artURLs[artPath]
+	if !ok || time.Now().After(cached.expires) {
+		delete(h.artURLs, artPath)
+		return ""
+	}
+	return cached.url
+}
+
+func (h *Handler) cacheArtURL(artPath, url string) {
+	h.artMu.Lock()
+	defer h.artMu.Unlock()
+
+	h.artURLs[artPath] = artURL{url: url, expires: time.Now().Add(artURLTTL)}
+}
+
+// sendEmbedWithArt sends embed with album art as its image. Art is only
+// uploaded the first time; later embeds link to the uploaded copy. It
+// returns false if the art couldn't be sent, leaving the caller to send
+// the embed without it.
+func (h *Handler) sendEmbedWithArt(s *discordgo.Session, channelID string, embed *discordgo.MessageEmbed, artPath string) bool {
+	if url := h.cachedArtURL(artPath); url != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: url}
+		s.ChannelMessageSendEmbed(channelID, embed)
+		return true
+	}
+
+	artData, err := os.ReadFile(artPath)
+	if err != nil {
+		return false
+	}
+
+	// Send as message with embed and file attachment
+	fileName := filepath.Base(artPath)
+	embed.Image = &discordgo.MessageEmbedImage{
+		URL: "attachment://" + fileName,
+	}
+
+	msg, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embed: embed,
+		Files: []*discordgo.File{
+			{
+				Name:   fileName,
+				Reader: bytes.NewReader(artData),
+			},
+		},
+	})
+	if err != nil {
+		embed.Image = nil
+		return false
+	}
+
+	if len(msg.Embeds) > 0 && msg.Embeds[0].Image != nil && msg.Embeds[0].Image.URL != "" {
+		h.cacheArtURL(artPath, msg.Embeds[0].Image.URL)
+	} else if len(msg.Attachments) > 0 {
+		h.cacheArtURL(artPath, msg.Attachments[0].URL)
+	}
+	return true
+}
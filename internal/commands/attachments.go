@@ -0,0 +1,146 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handlePlayFile plays the audio files attached to the message the command
+// replies to.
+func (h *Handler) handlePlayFile(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.MessageReference == nil {
+		s.ChannelMessageSend(m.ChannelID, "Reply to a message with an audio file to play it!")
+		return
+	}
+
+	// Discord usually includes the replied-to message, but not always
+	referenced := m.ReferencedMessage
+	if referenced == nil {
+		var err error
+		referenced, err = s.ChannelMessage(m.MessageReference.ChannelID, m.MessageReference.MessageID)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, "Couldn't load the message you replied to!")
+			return
+		}
+	}
+
+	if len(referenced.Attachments) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "That message has no attached files!")
+		return
+	}
+
+	h.playAttachments(s, m, referenced.Attachments)
+}
+
+// playAttachments queues every playable attachment, naming the ones it had
+// to skip.
+func (h *Handler) playAttachments(s *discordgo.Session, m *discordgo.MessageCreate, attachments []*discordgo.MessageAttachment) {
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanAddMusic(userLevel) {
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to add music!")
+		return
+	}
+
+	// Attachments are fetched over HTTP, so they follow that source's switch
+	if !h.checkSource(s, m, h.guildSources(m.GuildID), music.SourceHTTP) {
+		return
+	}
+
+	voiceChannel, err := h.getUserVoiceChannel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "You must be in a voice channel!")
+		return
+	}
+
+	if !h.voiceChannelAllowed(m.GuildID, voiceChannel) {
+		s.ChannelMessageSend(m.ChannelID, "I'm not allowed to join that voice channel!")
+		return
+	}
+
+	var playable []*discordgo.MessageAttachment
+	var skipped []string
+	for _, attachment := range attachments {
+		if err := h.attachments.Check(attachment.Filename, attachment.ContentType, attachment.Size); err != nil {
+			skipped = append(skipped, err.Error())
+			continue
+		}
+		playable = append(playable, attachment)
+	}
+
+	if len(playable) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Can't play that: %s", strings.Join(skipped, "; ")))
+		return
+	}
+
+	msg, _ := s.ChannelMessageSend(m.ChannelID, "Fetching track information...")
+
+	player := h.queueMgr.GetPlayer(m.GuildID)
+
+	if err := player.Connect(s, voiceChannel); err != nil {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error connecting to voice channel: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), music.AttachmentProbeTimeout)
+	defer cancel()
+
+	var added []string
+	for _, attachment := range playable {
+		track := h.attachments.Track(ctx, attachment.ID, attachment.Filename, attachment.URL, attachment.Size, attachment.DurationSecs, m.Author.ID)
+		if err := h.queueMgr.AddTrack(m.GuildID, voiceChannel, m.Author.ID, track); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", attachment.Filename, err))
+			continue
+		}
+		added = append(added, track.Title)
+	}
+
+	var content string
+	switch len(added) {
+	case 0:
+		content = "Error adding track: " + strings.Join(skipped, "; ")
+	case 1:
+		content = fmt.Sprintf("Added to queue: **%s**", added[0])
+	default:
+		content = fmt.Sprintf("Added %d uploaded files to queue", len(added))
+	}
+	if len(added) > 0 && len(skipped) > 0 {
+		content += fmt.Sprintf("\nSkipped: %s", strings.Join(skipped, "; "))
+	}
+	s.ChannelMessageEdit(m.ChannelID, msg.ID, content)
+
+	if len(added) > 0 && !player.IsPlaying() {
+		player.Play()
+	}
+}
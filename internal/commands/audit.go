@@ -0,0 +1,200 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"miku_bot/internal/database"
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Audit outcomes. Failures are recorded as "error: <message>".
+const (
+	auditSuccess = "success"
+	auditDenied  = "denied"
+)
+
+func auditError(err error) string {
+	return "error: " + err.Error()
+}
+
+// audit records a privileged action and mirrors it to the guild's log
+// channel if one is configured. Failures are logged, never surfaced to the
+// user, so auditing can't break the command itself.
+func (h *Handler) audit(s *discordgo.Session, m *discordgo.MessageCreate, command string, args []string, outcome string) {
+	entry := &database.AuditEntry{
+		GuildID:   m.GuildID,
+		UserID:    m.Author.ID,
+		Command:   command,
+		Arguments: strings.Join(args, " "),
+		Outcome:   outcome,
+	}
+
+	h.recordAudit(s, entry)
+}
+
+// AuditDenied records a queued track refused when it came up to play, such
+// as a local file that no longer resolves inside the guild's library. It
+// is recorded against whoever queued the track.
+func (h *Handler) AuditDenied(s *discordgo.Session, guildID string, track *music.Track, err error) {
+	h.recordAudit(s, &database.AuditEntry{
+		GuildID:   guildID,
+		UserID:    track.Requester,
+		Command:   "play",
+		Arguments: track.URL,
+		Outcome:   auditError(err),
+	})
+}
+
+func (h *Handler) recordAudit(s *discordgo.Session, entry *database.AuditEntry) {
+	if err := h.db.AddAuditEntry(entry); err != nil {
+		log.Printf("Failed to write audit entry for %s in guild %s: %v", entry.Command, entry.GuildID, err)
+	}
+
+	guild, err := h.db.GetGuild(entry.GuildID)
+	if err != nil || !guild.LogChannelID.Valid {
+		return
+	}
+
+	channel, err := lookupChannel(s, guild.LogChannelID.String)
+	if err != nil || channel.GuildID != entry.GuildID {
+		log.Printf("Not sending audit entry for guild %s to log channel %s outside the guild", entry.GuildID, guild.LogChannelID.String)
+		return
+	}
+
+	if _, err := s.ChannelMessageSendEmbed(guild.LogChannelID.String, auditEmbed(entry)); err != nil {
+		log.Printf("Failed to send audit entry to log channel in guild %s: %v", entry.GuildID, err)
+	}
+}
+
+// embedFieldLimit is the most characters Discord accepts in an embed field
+// value.
+const embedFieldLimit = 1024
+
+func auditEmbed(entry *database.AuditEntry) *discordgo.MessageEmbed {
+	color := 0x9B59B6
+	if entry.Outcome != auditSuccess {
+		color = 0xE74C3C
+	}
+
+	arguments := "-"
+	if entry.Arguments != "" {
+		// Leave room for the backticks and the ellipsis codeSpanText adds.
+		arguments = "`" + codeSpanText(entry.Arguments, embedFieldLimit-5) + "`"
+	}
+
+	return &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("Audit: !%s", entry.Command),
+		Color: color,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "User",
+				Value:  fmt.Sprintf("<@%s>", entry.UserID),
+				Inline: true,
+			},
+			{
+				Name:   "Outcome",
+				Value:  entry.Outcome,
+				Inline: true,
+			},
+			{
+				Name:   "Arguments",
+				Value:  arguments,
+				Inline: false,
+			},
+		},
+	}
+}
+
+// codeSpanText prepares text for an inline code span: backticks, which
+// would end the span, are swapped for quotes and the text is cut to at most
+// limit characters.
+func codeSpanText(text string, limit int) string {
+	text = strings.ReplaceAll(text, "`", "'")
+	if runes := []rune(text); len(runes) > limit {
+		return string(runes[:limit]) + "..."
+	}
+	return text
+}
+
+func (h *Handler) handleAudit(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanManageQueue(userLevel) {
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to view the audit log!")
+		return
+	}
+
+	userID := ""
+	command := ""
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "<@") {
+			id, ok := parseUserMention(arg)
+			if !ok {
+				s.ChannelMessageSend(m.ChannelID, "Please mention a user to filter by!")
+				return
+			}
+			userID = id
+		} else {
+			command = strings.TrimPrefix(strings.ToLower(arg), h.prefix)
+		}
+	}
+
+	entries, err := h.db.GetAuditLog(m.GuildID, userID, command, 15)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error reading audit log!")
+		return
+	}
+
+	if len(entries) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "No matching audit log entries!")
+		return
+	}
+
+	entriesList := ""
+	for _, entry := range entries {
+		line := fmt.Sprintf("<t:%d:g> <@%s> `!%s", entry.CreatedAt.Unix(), entry.UserID, entry.Command)
+		if arguments := entry.Arguments; arguments != "" {
+			line += " " + codeSpanText(arguments, 100)
+		}
+		line += fmt.Sprintf("` - %s\n", entry.Outcome)
+		entriesList += line
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Audit Log",
+		Description: entriesList,
+		Color:       0x9B59B6,
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
@@ -0,0 +1,297 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"miku_bot/internal/database"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// parseBanDuration accepts Go durations plus day and week suffixes,
+// e.g. "30m", "12h", "7d" or "2w".
+func parseBanDuration(value string) (time.Duration, bool) {
+	value = strings.ToLower(value)
+
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, suffix)); err == nil && strings.HasSuffix(value, suffix) && n > 0 {
+			return time.Duration(n) * unit, true
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, false
+	}
+	return duration, true
+}
+
+// parseUserMention returns the user ID from a <@id> or <@!id> mention or a
+// bare ID. Role mentions (<@&id>) and anything else are rejected.
+func parseUserMention(arg string) (string, bool) {
+	id := arg
+	if strings.HasPrefix(arg, "<@") && strings.HasSuffix(arg, ">") {
+		id = strings.TrimPrefix(arg[2:len(arg)-1], "!")
+	}
+	if id == "" {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// isBotBanned reports whether the user is currently banned from using the
+// bot in this guild. A guild's active bans are loaded once and cached until
+// a ban command changes them; expired entries are dropped as they're seen.
+// Lookup errors are logged and treated as not banned.
+func (h *Handler) isBotBanned(guildID, userID string) bool {
+	h.banMu.Lock()
+	defer h.banMu.Unlock()
+
+	bans, ok := h.bans[guildID]
+	if !ok {
+		active, err := h.db.GetActiveBotBans(guildID)
+		if err != nil {
+			log.Printf("Failed to load bot bans for guild %s: %v", guildID, err)
+			return false
+		}
+		bans = make(map[string]sql.NullTime, len(active))
+		for _, ban := range active {
+			bans[ban.UserID] = ban.ExpiresAt
+		}
+		h.bans[guildID] = bans
+	}
+
+	expiresAt, banned := bans[userID]
+	if !banned {
+		return false
+	}
+	if expiresAt.Valid && !time.Now().Before(expiresAt.Time) {
+		delete(bans, userID)
+		return false
+	}
+	return true
+}
+
+// invalidateBans drops the cached bans for a guild so the next check
+// reloads them from the database.
+func (h *Handler) invalidateBans(guildID string) {
+	h.banMu.Lock()
+	defer h.banMu.Unlock()
+
+	delete(h.bans, guildID)
+}
+
+func (h *Handler) handleBotBan(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!botban @user [duration] [reason] [--purge]`")
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanBanUsers(userLevel) {
+		h.audit(s, m, "botban", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to ban users from the bot!")
+		return
+	}
+
+	userID, ok := parseUserMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, "Please mention a user to ban!")
+		return
+	}
+	if userID == m.Author.ID {
+		s.ChannelMessageSend(m.ChannelID, "You can't ban yourself!")
+		return
+	}
+
+	targetLevel, err := perm.GetUserLevel(s, m.GuildID, userID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "User not found in this server!")
+		return
+	}
+
+	if targetLevel >= userLevel {
+		s.ChannelMessageSend(m.ChannelID, "You can't ban someone with the same or a higher permission level!")
+		return
+	}
+
+	ban := &database.BotBan{
+		GuildID:  m.GuildID,
+		UserID:   userID,
+		BannedBy: m.Author.ID,
+	}
+
+	purge := false
+	var reasonWords []string
+	for i, arg := range args[1:] {
+		if arg == "--purge" {
+			purge = true
+			continue
+		}
+		if duration, ok := parseBanDuration(arg); ok && i == 0 {
+			ban.ExpiresAt = sql.NullTime{Time: time.Now().UTC().Add(duration), Valid: true}
+			continue
+		}
+		reasonWords = append(reasonWords, arg)
+	}
+	ban.Reason = strings.Join(reasonWords, " ")
+
+	if err := h.db.AddBotBan(ban); err != nil {
+		h.audit(s, m, "botban", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "Error saving ban!")
+		return
+	}
+	h.invalidateBans(m.GuildID)
+
+	response := fmt.Sprintf("<@%s> is banned from using the bot", userID)
+	if ban.ExpiresAt.Valid {
+		response += fmt.Sprintf(" until <t:%d:f>", ban.ExpiresAt.Time.Unix())
+	}
+	if ban.Reason != "" {
+		response += fmt.Sprintf(" (reason: %s)", ban.Reason)
+	}
+
+	if purge {
+		removed, err := h.queueMgr.RemoveUserTracks(m.GuildID, userID)
+		if err != nil {
+			response += fmt.Sprintf("\nError purging their tracks: %v", err)
+		} else {
+			response += fmt.Sprintf("\nRemoved %d of their tracks from the queue", removed)
+		}
+	}
+
+	h.audit(s, m, "botban", args, auditSuccess)
+	s.ChannelMessageSend(m.ChannelID, response)
+}
+
+func (h *Handler) handleBotUnban(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!botunban @user`")
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanBanUsers(userLevel) {
+		h.audit(s, m, "botunban", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to unban users from the bot!")
+		return
+	}
+
+	userID, ok := parseUserMention(args[0])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, "Please mention a user to unban!")
+		return
+	}
+
+	removed, err := h.db.RemoveBotBan(m.GuildID, userID)
+	if err != nil {
+		h.audit(s, m, "botunban", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "Error removing ban!")
+		return
+	}
+	h.invalidateBans(m.GuildID)
+
+	if !removed {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> is not banned", userID))
+		return
+	}
+
+	h.audit(s, m, "botunban", args, auditSuccess)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> can use the bot again", userID))
+}
+
+func (h *Handler) handleBotBans(s *discordgo.Session, m *discordgo.MessageCreate) {
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanBanUsers(userLevel) {
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to view bot bans!")
+		return
+	}
+
+	bans, err := h.db.GetActiveBotBans(m.GuildID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error getting bans!")
+		return
+	}
+
+	if len(bans) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Nobody is banned from using the bot!")
+		return
+	}
+
+	bansList := ""
+	for i, ban := range bans {
+		if i >= 20 {
+			bansList += fmt.Sprintf("\n...and %d more bans", len(bans)-20)
+			break
+		}
+
+		expiry := "permanent"
+		if ban.ExpiresAt.Valid {
+			expiry = fmt.Sprintf("expires <t:%d:R>", ban.ExpiresAt.Time.Unix())
+		}
+		bansList += fmt.Sprintf("%d. <@%s> - %s, by <@%s>", i+1, ban.UserID, expiry, ban.BannedBy)
+		if ban.Reason != "" {
+			bansList += fmt.Sprintf(" (%s)", ban.Reason)
+		}
+		bansList += "\n"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Bot Bans",
+		Description: bansList,
+		Color:       0x9B59B6,
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
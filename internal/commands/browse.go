@@ -0,0 +1,549 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// browseLimit caps how many entries a browse listing shows.
+const browseLimit = 20
+
+func (h *Handler) handleArtists(s *discordgo.Session, m *discordgo.MessageCreate) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	artists := library.Artists()
+	if len(artists) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "No artists found! Make sure your files have artist tags.")
+		return
+	}
+
+	list := ""
+	for i, artist := range artists {
+		if i >= browseLimit*2 {
+			list += fmt.Sprintf("\n...and %d more artists", len(artists)-browseLimit*2)
+			break
+		}
+		list += fmt.Sprintf("**%s** (%d albums, %d tracks)\n", artist.Name, artist.Albums, artist.Tracks)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "🎤 Artists",
+		Description: list,
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Total: %d artists • Use !albums <artist> to see their albums", len(artists)),
+		},
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+func (h *Handler) handleAlbums(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!albums <artist>`")
+		return
+	}
+
+	artist := strings.Join(args, " ")
+	albums := library.AlbumsByArtist(artist)
+	if len(albums) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No albums found for: %s", artist))
+		return
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("💿 Albums by %s", artist),
+		Description: formatAlbumList(albums),
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Total: %d albums • Use !album <name> to see the tracks", len(albums)),
+		},
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+func (h *Handler) handleAlbum(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!album <name>`")
+		return
+	}
+
+	album, ok := h.findAlbum(s, m, library, strings.Join(args, " "))
+	if !ok {
+		return
+	}
+
+	list := ""
+	total := 0
+	for i, file := range album.Tracks {
+		total += file.Duration
+		if i < browseLimit*2 {
+			list += fmt.Sprintf("%s. %s%s\n", trackNumberLabel(file, i), displayName(file), formatTrackLength(file.Duration))
+		}
+	}
+	if len(album.Tracks) > browseLimit*2 {
+		list += fmt.Sprintf("\n...and %d more tracks", len(album.Tracks)-browseLimit*2)
+	}
+
+	length := ""
+	if total > 0 {
+		length = ", " + formatDuration(total)
+	}
+
+	title := album.Name
+	if album.Year > 0 {
+		title = fmt.Sprintf("%s (%d)", album.Name, album.Year)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "💿 " + title,
+		Description: list,
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%s • %d tracks%s • Use !playalbum %s to queue it",
+				album.Artist, len(album.Tracks), length, album.Name),
+		},
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+func (h *Handler) handlePlayAlbum(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!playalbum <name>`")
+		return
+	}
+
+	album, ok := h.findAlbum(s, m, library, strings.Join(args, " "))
+	if !ok {
+		return
+	}
+
+	h.enqueueLocalFiles(s, m, library, album.Tracks, fmt.Sprintf("**%s** by %s", album.Name, album.Artist))
+}
+
+func (h *Handler) handleLocalFolder(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	const usage = "Usage: `!localfolder <folder> [--recursive] [--shuffle] [--sort name|track|date]`"
+
+	recursive, shuffle := false, false
+	order := music.OrderName
+	var folderWords []string
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "--recursive", "-r":
+			recursive = true
+		case "--shuffle", "-s":
+			shuffle = true
+		case "--sort":
+			if i+1 >= len(args) {
+				s.ChannelMessageSend(m.ChannelID, usage)
+				return
+			}
+			i++
+			order = strings.ToLower(args[i])
+		default:
+			folderWords = append(folderWords, args[i])
+		}
+	}
+
+	if len(folderWords) == 0 {
+		s.ChannelMessageSend(m.ChannelID, usage)
+		return
+	}
+	folder := strings.Join(folderWords, " ")
+
+	files, err := library.FolderFiles(folder, recursive, order)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	if shuffle {
+		rand.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+	}
+
+	source := fmt.Sprintf("**%s**", folder)
+	if recursive {
+		source += " and its subfolders"
+	}
+	h.enqueueLocalFiles(s, m, library, files, source)
+}
+
+func (h *Handler) handleLocalPlaylist(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		playlists := library.Playlists()
+		if len(playlists) == 0 {
+			s.ChannelMessageSend(m.ChannelID, "No playlists found! Put .m3u or .pls files in your music folder.")
+			return
+		}
+
+		list := ""
+		for i, playlist := range playlists {
+			if i >= browseLimit*2 {
+				list += fmt.Sprintf("\n...and %d more playlists", len(playlists)-browseLimit*2)
+				break
+			}
+			list += fmt.Sprintf("**%s** (in %s)\n", playlist.Name, library.PlaylistLocation(playlist))
+		}
+
+		embed := &discordgo.MessageEmbed{
+			Title:       "📜 Local Playlists",
+			Description: list,
+			Color:       0x9B59B6,
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Total: %d playlists • Use !localplaylist <name> to queue one", len(playlists)),
+			},
+		}
+
+		s.ChannelMessageSendEmbed(m.ChannelID, embed)
+		return
+	}
+
+	name := strings.Join(args, " ")
+	playlists := library.FindPlaylists(name)
+	switch {
+	case len(playlists) == 0:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No playlist found matching: %s", name))
+		return
+	case len(playlists) > 1:
+		list := ""
+		for i, playlist := range playlists {
+			if i >= browseLimit {
+				list += fmt.Sprintf("\n...and %d more playlists", len(playlists)-browseLimit)
+				break
+			}
+			list += fmt.Sprintf("**%s** (in %s)\n", playlist.Name, library.PlaylistLocation(playlist))
+		}
+
+		embed := &discordgo.MessageEmbed{
+			Title:       fmt.Sprintf("Multiple playlists match: %s", name),
+			Description: list,
+			Color:       0x9B59B6,
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: "Please use a more specific playlist name",
+			},
+		}
+		s.ChannelMessageSendEmbed(m.ChannelID, embed)
+		return
+	}
+
+	playlist := playlists[0]
+	files, missing, err := library.ResolvePlaylist(playlist)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	if len(files) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("None of the %d entries in **%s** were found in the library!", len(missing), playlist.Name))
+		return
+	}
+
+	h.enqueueLocalFiles(s, m, library, files, fmt.Sprintf("playlist **%s**", playlist.Name))
+
+	if len(missing) > 0 {
+		list := ""
+		for i, entry := range missing {
+			if i >= browseLimit/2 {
+				list += fmt.Sprintf("\n...and %d more", len(missing)-browseLimit/2)
+				break
+			}
+			list += fmt.Sprintf("`%s`\n", entry)
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Skipped %d entries that aren't in the library:\n%s", len(missing), list))
+	}
+}
+
+func (h *Handler) handleGenre(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		genres := library.Genres()
+		if len(genres) == 0 {
+			s.ChannelMessageSend(m.ChannelID, "No genres found! Make sure your files have genre tags.")
+			return
+		}
+
+		list := ""
+		for i, genre := range genres {
+			if i >= browseLimit*2 {
+				list += fmt.Sprintf("\n...and %d more genres", len(genres)-browseLimit*2)
+				break
+			}
+			list += fmt.Sprintf("**%s** (%d tracks)\n", genre.Name, genre.Tracks)
+		}
+
+		embed := &discordgo.MessageEmbed{
+			Title:       "🏷️ Genres",
+			Description: list,
+			Color:       0x9B59B6,
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: fmt.Sprintf("Total: %d genres • Use !genre <name> to see the tracks", len(genres)),
+			},
+		}
+
+		s.ChannelMessageSendEmbed(m.ChannelID, embed)
+		return
+	}
+
+	genre := strings.Join(args, " ")
+	files := library.FilesByGenre(genre)
+	if len(files) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No tracks found in genre: %s", genre))
+		return
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, trackListEmbed(library, "🏷️ "+genre, files))
+}
+
+func (h *Handler) handleYear(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!year <year>`")
+		return
+	}
+
+	year, err := strconv.Atoi(args[0])
+	if err != nil || year <= 0 {
+		s.ChannelMessageSend(m.ChannelID, "Invalid year!")
+		return
+	}
+
+	files := library.FilesByYear(year)
+	if len(files) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No tracks found from %d", year))
+		return
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, trackListEmbed(library, fmt.Sprintf("📅 %d", year), files))
+}
+
+// findAlbum looks up a single album by name. When several albums match, it
+// lists them and asks the user to be more specific.
+func (h *Handler) findAlbum(s *discordgo.Session, m *discordgo.MessageCreate, library *music.GuildLibrary, name string) (*music.Album, bool) {
+	albums := library.FindAlbums(name)
+	switch len(albums) {
+	case 0:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No album found matching: %s", name))
+		return nil, false
+	case 1:
+		return albums[0], true
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Multiple albums match: %s", name),
+		Description: formatAlbumList(albums),
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Please use a more specific album name",
+		},
+	}
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+	return nil, false
+}
+
+// enqueueLocalFiles adds library files to the queue in order and starts
+// playback. source describes where multiple files came from for the reply.
+func (h *Handler) enqueueLocalFiles(s *discordgo.Session, m *discordgo.MessageCreate, library *music.GuildLibrary, files []*music.LocalFile, source string) {
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanAddMusic(userLevel) {
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to add music!")
+		return
+	}
+
+	voiceChannel, err := h.getUserVoiceChannel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "You must be in a voice channel!")
+		return
+	}
+
+	if !h.voiceChannelAllowed(m.GuildID, voiceChannel) {
+		s.ChannelMessageSend(m.ChannelID, "I'm not allowed to join that voice channel!")
+		return
+	}
+
+	if !h.checkSource(s, m, h.guildSources(m.GuildID), music.SourceLocal) {
+		return
+	}
+
+	// Only look at as many files as there is room for
+	full := 0
+	if space := h.queueMgr.QueueSpace(m.GuildID); space >= 0 && len(files) > space {
+		if space == 0 {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("The queue is full! (%d tracks max)", h.queueMgr.MaxQueueSize()))
+			return
+		}
+		full = len(files) - space
+		files = files[:space]
+	}
+
+	allowed := h.checkLocalFiles(s, m, library, files)
+	if len(allowed) < len(files) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Skipped %d tracks that are outside the music library!", len(files)-len(allowed)))
+	}
+	if len(allowed) == 0 {
+		return
+	}
+	files = allowed
+
+	player := h.queueMgr.GetPlayer(m.GuildID)
+
+	if err := player.Connect(s, voiceChannel); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error connecting to voice channel: %v", err))
+		return
+	}
+
+	tracks := make([]*music.Track, 0, len(files))
+	for _, file := range files {
+		tracks = append(tracks, localTrack(file, m.Author.ID))
+	}
+
+	added, err := h.queueMgr.AddTracks(m.GuildID, voiceChannel, m.Author.ID, tracks)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error adding track: %v", err))
+		return
+	}
+	if full += len(tracks) - added; full > 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("The queue is full! Skipped the remaining %d tracks.", full))
+	}
+
+	switch {
+	case added == 0:
+		return
+	case len(files) == 1:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added to queue: **%s** (from %s)", files[0].Name, library.Location(files[0])))
+	default:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added %d tracks to queue from %s", added, source))
+	}
+
+	if !player.IsPlaying() {
+		player.Play()
+	}
+}
+
+func formatAlbumList(albums []*music.Album) string {
+	list := ""
+	for i, album := range albums {
+		if i >= browseLimit {
+			list += fmt.Sprintf("\n...and %d more albums", len(albums)-browseLimit)
+			break
+		}
+		year := ""
+		if album.Year > 0 {
+			year = fmt.Sprintf(" (%d)", album.Year)
+		}
+		list += fmt.Sprintf("**%s**%s - %s, %d tracks\n", album.Name, year, album.Artist, len(album.Tracks))
+	}
+	return list
+}
+
+func trackListEmbed(library *music.GuildLibrary, title string, files []*music.LocalFile) *discordgo.MessageEmbed {
+	list := ""
+	for i, file := range files {
+		if i >= browseLimit {
+			list += fmt.Sprintf("\n...and %d more tracks", len(files)-browseLimit)
+			break
+		}
+		artist := ""
+		if file.Artist != "" {
+			artist = file.Artist + " - "
+		}
+		list += fmt.Sprintf("%d. %s%s (in %s)\n", i+1, artist, displayName(file), library.Location(file))
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       title,
+		Description: list,
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Total: %d tracks", len(files)),
+		},
+	}
+}
+
+// displayName prefers the title tag over the filename.
+func displayName(file *music.LocalFile) string {
+	if file.Title != "" {
+		return file.Title
+	}
+	return file.Name
+}
+
+func trackNumberLabel(file *music.LocalFile, index int) string {
+	if file.TrackNumber == 0 {
+		return strconv.Itoa(index + 1)
+	}
+	if file.DiscNumber > 1 {
+		return fmt.Sprintf("%d-%d", file.DiscNumber, file.TrackNumber)
+	}
+	return strconv.Itoa(file.TrackNumber)
+}
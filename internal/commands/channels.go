@@ -0,0 +1,232 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"miku_bot/internal/database"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// channelAllowed reports whether a channel of the given kind may be used.
+// Denied channels are always refused; once any channel of a kind has been
+// allowed, only allowed channels of that kind are accepted.
+func channelAllowed(rules []*database.ChannelRule, kind, channelID string) bool {
+	hasAllowList := false
+	allowed := false
+
+	for _, rule := range rules {
+		if rule.Kind != kind {
+			continue
+		}
+		if rule.ChannelID == channelID && rule.Mode == database.ChannelModeDeny {
+			return false
+		}
+		if rule.Mode == database.ChannelModeAllow {
+			hasAllowList = true
+			if rule.ChannelID == channelID {
+				allowed = true
+			}
+		}
+	}
+
+	return !hasAllowList || allowed
+}
+
+func (h *Handler) textChannelAllowed(s *discordgo.Session, guildID, channelID string) bool {
+	rules, err := h.db.GetChannelRules(guildID)
+	if err != nil {
+		log.Printf("Failed to load channel rules for guild %s: %v", guildID, err)
+		return true
+	}
+
+	if len(rules) == 0 {
+		return true
+	}
+
+	// Threads inherit the restrictions of their parent channel
+	if channel, err := lookupChannel(s, channelID); err == nil && channel.IsThread() {
+		channelID = channel.ParentID
+	}
+
+	return channelAllowed(rules, database.ChannelKindText, channelID)
+}
+
+func (h *Handler) voiceChannelAllowed(guildID, channelID string) bool {
+	rules, err := h.db.GetChannelRules(guildID)
+	if err != nil {
+		log.Printf("Failed to load channel rules for guild %s: %v", guildID, err)
+		return true
+	}
+
+	return channelAllowed(rules, database.ChannelKindVoice, channelID)
+}
+
+func lookupChannel(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	if channel, err := s.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+	return s.Channel(channelID)
+}
+
+var channelLabels = map[string]string{
+	database.ChannelKindText:  "Text",
+	database.ChannelKindVoice: "Voice",
+	database.ChannelModeAllow: "allowed",
+	database.ChannelModeDeny:  "denied",
+}
+
+func channelKind(channel *discordgo.Channel) string {
+	switch channel.Type {
+	case discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice:
+		return database.ChannelKindVoice
+	}
+	return database.ChannelKindText
+}
+
+func (h *Handler) handleChannels(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) == 0 || strings.ToLower(args[0]) == "list" {
+		h.listChannelRules(s, m)
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanChangeSettings(userLevel) {
+		h.audit(s, m, "channels", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to change settings!")
+		return
+	}
+
+	action := strings.ToLower(args[0])
+
+	if action == "clear" {
+		if err := h.db.ClearChannelRules(m.GuildID); err != nil {
+			h.audit(s, m, "channels", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error updating channel restrictions!")
+			return
+		}
+		h.audit(s, m, "channels", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, "Cleared all channel restrictions!")
+		return
+	}
+
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!channels allow/deny/reset <#channel>` or `!channels clear`")
+		return
+	}
+
+	channelID := strings.Trim(args[1], "<#>")
+	channel, err := lookupChannel(s, channelID)
+	if err != nil || channel.GuildID != m.GuildID {
+		s.ChannelMessageSend(m.ChannelID, "Channel not found in this server!")
+		return
+	}
+
+	switch action {
+	case "allow", "deny":
+		mode := database.ChannelModeAllow
+		if action == "deny" {
+			mode = database.ChannelModeDeny
+		}
+
+		rule := &database.ChannelRule{
+			GuildID:   m.GuildID,
+			ChannelID: channelID,
+			Kind:      channelKind(channel),
+			Mode:      mode,
+		}
+
+		if err := h.db.SetChannelRule(rule); err != nil {
+			h.audit(s, m, "channels", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error updating channel restrictions!")
+			return
+		}
+
+		h.audit(s, m, "channels", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s channel <#%s> is now %s", channelLabels[rule.Kind], channelID, channelLabels[mode]))
+	case "reset", "remove":
+		removed, err := h.db.RemoveChannelRule(m.GuildID, channelID)
+		if err != nil {
+			h.audit(s, m, "channels", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error updating channel restrictions!")
+			return
+		}
+		if !removed {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<#%s> has no restriction", channelID))
+			return
+		}
+		h.audit(s, m, "channels", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Removed restriction for <#%s>", channelID))
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!channels allow/deny/reset <#channel>` or `!channels clear`")
+	}
+}
+
+func (h *Handler) listChannelRules(s *discordgo.Session, m *discordgo.MessageCreate) {
+	rules, err := h.db.GetChannelRules(m.GuildID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error getting channel restrictions!")
+		return
+	}
+
+	if len(rules) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "No channel restrictions set, the bot can be used everywhere!")
+		return
+	}
+
+	lists := map[string]string{}
+	for _, rule := range rules {
+		key := rule.Kind + " " + rule.Mode
+		lists[key] += fmt.Sprintf("<#%s>\n", rule.ChannelID)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: "Channel Restrictions",
+		Color: 0x9B59B6,
+	}
+
+	for _, kind := range []string{database.ChannelKindText, database.ChannelKindVoice} {
+		for _, mode := range []string{database.ChannelModeAllow, database.ChannelModeDeny} {
+			value, ok := lists[kind+" "+mode]
+			if !ok {
+				continue
+			}
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:   fmt.Sprintf("%s channels (%s)", channelLabels[kind], channelLabels[mode]),
+				Value:  value,
+				Inline: true,
+			})
+		}
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
@@ -0,0 +1,125 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// choiceTTL is how long a user has to answer with !pick.
+const choiceTTL = 2 * time.Minute
+
+// pendingChoice holds the files a user was last offered, so !pick can
+// refer to them by number.
+type pendingChoice struct {
+	files   []*music.LocalFile
+	expires time.Time
+}
+
+func choiceKey(guildID, userID string) string {
+	return guildID + ":" + userID
+}
+
+func (h *Handler) setChoice(guildID, userID string, files []*music.LocalFile) {
+	h.choiceMu.Lock()
+	defer h.choiceMu.Unlock()
+
+	// Drop stale choices so the map doesn't grow forever
+	now := time.Now()
+	for key, choice := range h.choices {
+		if now.After(choice.expires) {
+			delete(h.choices, key)
+		}
+	}
+
+	h.choices[choiceKey(guildID, userID)] = &pendingChoice{
+		files:   files,
+		expires: now.Add(choiceTTL),
+	}
+}
+
+func (h *Handler) getChoice(guildID, userID string) []*music.LocalFile {
+	h.choiceMu.Lock()
+	defer h.choiceMu.Unlock()
+
+	choice, ok := h.choices[choiceKey(guildID, userID)]
+	if !ok || time.Now().After(choice.expires) {
+		return nil
+	}
+	return choice.files
+}
+
+// offerChoice lists candidate files and remembers them for !pick.
+func (h *Handler) offerChoice(s *discordgo.Session, m *discordgo.MessageCreate, library *music.GuildLibrary, title string, files []*music.LocalFile) {
+	list := ""
+	for i, file := range files {
+		artist := ""
+		if file.Artist != "" {
+			artist = " - " + file.Artist
+		}
+		list += fmt.Sprintf("%d. **%s**%s (in %s)\n", i+1, file.Name, artist, library.Location(file))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       title,
+		Description: list,
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Use !pick <number> to play one",
+		},
+	}
+
+	h.setChoice(m.GuildID, m.Author.ID, files)
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+func (h *Handler) handlePick(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!pick <number>`")
+		return
+	}
+
+	files := h.getChoice(m.GuildID, m.Author.ID)
+	if len(files) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Nothing to pick from! Use `!search` or `!local` first.")
+		return
+	}
+
+	choice, err := strconv.Atoi(args[0])
+	if err != nil || choice < 1 || choice > len(files) {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Invalid choice! Pick a number from 1 to %d.", len(files)))
+		return
+	}
+
+	h.enqueueLocalFiles(s, m, library, files[choice-1:choice], "")
+}
@@ -22,11 +22,14 @@
 package commands
 
 import (
-	"bytes"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"log"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"miku_bot/internal/database"
 	"miku_bot/internal/music"
@@ -40,17 +43,46 @@ type Handler struct {
 	db          *database.Database
 	queueMgr    *queue.Manager
 	permissions map[string]*permissions.Permission
+	permMu      sync.Mutex
 	prefix      string
-	library     *music.Library
+	libraries   *music.Libraries
+	attachments *music.Attachments
+	resolvers   *music.Resolvers
+	hosts       *music.HostFilter
+	sources     music.SourceSet
+	choices     map[string]*pendingChoice
+	choiceMu    sync.Mutex
+	artURLs     map[string]artURL
+	artMu       sync.Mutex
+	bans        map[string]map[string]sql.NullTime // Guild ID -> user ID -> ban expiry
+	banMu       sync.Mutex
+
+	// memberEvents is set when the bot has the members intent
+	memberEvents bool
+	// activity tracks voice activity for the alone-DJ idle check
+	activity *permissions.Activity
+
+	liveUpdaters map[string]*liveUpdater // Guild ID -> live now-playing updater
+	liveMu       sync.Mutex
 }
 
-func NewHandler(db *database.Database, queueMgr *queue.Manager, prefix string, library *music.Library) *Handler {
+func NewHandler(db *database.Database, queueMgr *queue.Manager, prefix string, libraries *music.Libraries, attachments *music.Attachments, resolvers *music.Resolvers, hosts *music.HostFilter, sources music.SourceSet, memberEvents bool) *Handler {
 	return &Handler{
-		db:          db,
-		queueMgr:    queueMgr,
-		permissions: make(map[string]*permissions.Permission),
-		prefix:      prefix,
-		library:     library,
+		db:           db,
+		queueMgr:     queueMgr,
+		permissions:  make(map[string]*permissions.Permission),
+		prefix:       prefix,
+		libraries:    libraries,
+		attachments:  attachments,
+		resolvers:    resolvers,
+		hosts:        hosts,
+		sources:      sources,
+		choices:      make(map[string]*pendingChoice),
+		artURLs:      make(map[string]artURL),
+		bans:         make(map[string]map[string]sql.NullTime),
+		liveUpdaters: make(map[string]*liveUpdater),
+		memberEvents: memberEvents,
+		activity:     permissions.NewActivity(),
 	}
 }
 
@@ -73,9 +105,25 @@ func (h *Handler) HandleMessage(s *discordgo.Session, m *discordgo.MessageCreate
 	command := strings.ToLower(args[0])
 	args = args[1:]
 
+	if h.isBotBanned(m.GuildID, m.Author.ID) {
+		return
+	}
+
+	// Stay silent in restricted channels, but let admins fix the
+	// restrictions from anywhere so they can't lock themselves out
+	if command != "channels" && !h.textChannelAllowed(s, m.GuildID, m.ChannelID) {
+		return
+	}
+
 	switch command {
 	case "play", "p":
 		h.handlePlay(s, m, args)
+	case "playfile", "pf":
+		h.handlePlayFile(s, m)
+	case "radio":
+		h.handleRadio(s, m, args)
+	case "podcast", "pod":
+		h.handlePodcast(s, m, args)
 	case "skip", "s":
 		h.handleSkip(s, m)
 	case "stop":
@@ -94,6 +142,8 @@ func (h *Handler) HandleMessage(s *discordgo.Session, m *discordgo.MessageCreate
 		h.handleClear(s, m)
 	case "movetop", "mt":
 		h.handleMoveTop(s, m, args)
+	case "move", "mv":
+		h.handleMove(s, m, args)
 	case "volume", "vol":
 		h.handleVolume(s, m, args)
 	case "join":
@@ -106,6 +156,24 @@ func (h *Handler) HandleMessage(s *discordgo.Session, m *discordgo.MessageCreate
 		h.handleSource(s, m)
 	case "setrole":
 		h.handleSetRole(s, m, args)
+	case "removerole":
+		h.handleRemoveRole(s, m, args)
+	case "roles":
+		h.handleRoles(s, m)
+	case "channels":
+		h.handleChannels(s, m, args)
+	case "sources":
+		h.handleSources(s, m, args)
+	case "settings":
+		h.handleSettings(s, m, args)
+	case "botban":
+		h.handleBotBan(s, m, args)
+	case "botunban":
+		h.handleBotUnban(s, m, args)
+	case "botbans":
+		h.handleBotBans(s, m)
+	case "audit":
+		h.handleAudit(s, m, args)
 	case "folders":
 		h.handleFolders(s, m)
 	case "files":
@@ -114,31 +182,164 @@ func (h *Handler) HandleMessage(s *discordgo.Session, m *discordgo.MessageCreate
 		h.handleLocalPlay(s, m, args)
 	case "search":
 		h.handleSearch(s, m, args)
+	case "rescan":
+		h.handleRescan(s, m)
+	case "artists":
+		h.handleArtists(s, m)
+	case "albums":
+		h.handleAlbums(s, m, args)
+	case "album":
+		h.handleAlbum(s, m, args)
+	case "playalbum", "pa":
+		h.handlePlayAlbum(s, m, args)
+	case "genre", "genres":
+		h.handleGenre(s, m, args)
+	case "year":
+		h.handleYear(s, m, args)
+	case "localfolder", "lf":
+		h.handleLocalFolder(s, m, args)
+	case "localplaylist", "lp":
+		h.handleLocalPlaylist(s, m, args)
+	case "pick":
+		h.handlePick(s, m, args)
 	}
 }
 
 func (h *Handler) getPermission(guildID string) *permissions.Permission {
+	h.permMu.Lock()
+	defer h.permMu.Unlock()
+
 	if perm, exists := h.permissions[guildID]; exists {
 		return perm
 	}
 
+	perm := permissions.New(nil, nil, "", h.memberEvents, h.activity)
+	if err := h.configurePermission(perm, guildID); err != nil {
+		return perm
+	}
+
+	h.permissions[guildID] = perm
+	return perm
+}
+
+// configurePermission applies a guild's stored roles and settings.
+func (h *Handler) configurePermission(perm *permissions.Permission, guildID string) error {
 	guild, err := h.db.GetGuild(guildID)
 	if err != nil {
-		return permissions.New("", "")
+		return err
 	}
 
-	djRole := ""
-	modRole := ""
-	if guild.DJRoleID.Valid {
-		djRole = guild.DJRoleID.String
+	djRoles, modRoles, err := h.loadRoles(guildID)
+	if err != nil {
+		return err
 	}
-	if guild.ModRoleID.Valid {
-		modRole = guild.ModRoleID.String
+
+	djAboveRole := ""
+	if guild.DJAboveRoleID.Valid {
+		djAboveRole = guild.DJAboveRoleID.String
 	}
 
-	perm := permissions.New(djRole, modRole)
-	h.permissions[guildID] = perm
-	return perm
+	perm.UpdateRoles(djRoles, modRoles, djAboveRole)
+	perm.SetOwnerPrivileges(guild.OwnerPrivileges)
+	perm.SetAloneDJ(guild.AloneDJ)
+	return nil
+}
+
+func (h *Handler) loadRoles(guildID string) (djRoles, modRoles []string, err error) {
+	roles, err := h.db.GetGuildRoles(guildID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, role := range roles {
+		switch role.Level {
+		case database.RoleLevelDJ:
+			djRoles = append(djRoles, role.RoleID)
+		case database.RoleLevelMod:
+			modRoles = append(modRoles, role.RoleID)
+		}
+	}
+
+	return djRoles, modRoles, nil
+}
+
+// reloadPermission refreshes a guild's cached permission from the database
+// after its roles or settings have changed.
+func (h *Handler) reloadPermission(guildID string) error {
+	return h.configurePermission(h.getPermission(guildID), guildID)
+}
+
+// cachedPermission returns a guild's permission only if it has already been
+// loaded, so gateway events for idle guilds don't touch the database.
+func (h *Handler) cachedPermission(guildID string) (*permissions.Permission, bool) {
+	h.permMu.Lock()
+	defer h.permMu.Unlock()
+
+	perm, exists := h.permissions[guildID]
+	return perm, exists
+}
+
+func (h *Handler) HandleMemberUpdate(s *discordgo.Session, e *discordgo.GuildMemberUpdate) {
+	if perm, ok := h.cachedPermission(e.GuildID); ok && e.User != nil {
+		perm.InvalidateMember(e.User.ID)
+	}
+}
+
+func (h *Handler) HandleRoleUpdate(s *discordgo.Session, e *discordgo.GuildRoleUpdate) {
+	if perm, ok := h.cachedPermission(e.GuildID); ok {
+		perm.InvalidateAll()
+	}
+}
+
+// HandleVoiceStateUpdate counts joins, moves, mutes and the like as voice
+// activity, so the alone-DJ check knows who is still paying attention.
+func (h *Handler) HandleVoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if v.ChannelID == "" {
+		h.activity.Forget(v.GuildID, v.UserID)
+		return
+	}
+	h.activity.Touch(v.GuildID, v.UserID)
+}
+
+// HandleSpeaking records that a member spoke in the bot's voice channel.
+func (h *Handler) HandleSpeaking(guildID, userID string) {
+	h.activity.Touch(guildID, userID)
+}
+
+// HandleRoleDelete drops a deleted role from the guild's configuration. Only
+// guilds whose permissions are loaded are checked, so deletions elsewhere
+// don't touch the database; a deleted role left configured in an idle guild
+// can never match a member again.
+func (h *Handler) HandleRoleDelete(s *discordgo.Session, e *discordgo.GuildRoleDelete) {
+	perm, ok := h.cachedPermission(e.GuildID)
+	if !ok {
+		return
+	}
+
+	dj, mod, djAbove := perm.RoleUse(e.RoleID)
+	if !dj && !mod && !djAbove {
+		// Members may have lost permissions along with the role
+		perm.InvalidateAll()
+		return
+	}
+
+	for level, configured := range map[string]bool{database.RoleLevelDJ: dj, database.RoleLevelMod: mod} {
+		if !configured {
+			continue
+		}
+		if _, err := h.db.RemoveGuildRole(e.GuildID, e.RoleID, level); err != nil {
+			log.Printf("Failed to remove deleted role %s from guild %s: %v", e.RoleID, e.GuildID, err)
+		}
+	}
+	if djAbove {
+		if err := h.db.UpdateGuildDJAboveRole(e.GuildID, ""); err != nil {
+			log.Printf("Failed to clear deleted DJ-above role %s in guild %s: %v", e.RoleID, e.GuildID, err)
+		}
+	}
+
+	if err := h.reloadPermission(e.GuildID); err != nil {
+		log.Printf("Failed to reload permissions for guild %s: %v", e.GuildID, err)
+	}
 }
 
 func (h *Handler) getUserVoiceChannel(s *discordgo.Session, guildID, userID string) (string, error) {
@@ -157,11 +358,21 @@ func (h *Handler) getUserVoiceChannel(s *discordgo.Session, guildID, userID stri
 }
 
 func (h *Handler) handlePlay(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(m.Attachments) > 0 {
+		h.playAttachments(s, m, m.Attachments)
+		return
+	}
+
 	if len(args) == 0 {
 		s.ChannelMessageSend(m.ChannelID, "Please provide a URL or search query!")
 		return
 	}
 
+	if path := strings.Join(args, " "); music.IsLocalPath(path) {
+		h.handlePlayPath(s, m, args, path)
+		return
+	}
+
 	perm := h.getPermission(m.GuildID)
 	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
 	if err != nil {
@@ -180,45 +391,95 @@ func (h *Handler) handlePlay(s *discordgo.Session, m *discordgo.MessageCreate, a
 		return
 	}
 
+	if !h.voiceChannelAllowed(m.GuildID, voiceChannel) {
+		s.ChannelMessageSend(m.ChannelID, "I'm not allowed to join that voice channel!")
+		return
+	}
+
 	url := strings.Join(args, " ")
+	sources := h.guildSources(m.GuildID)
 
 	if !strings.HasPrefix(url, "http") {
-		url = "ytsearch:" + url
+		if !sources[music.SourceYouTube] && sources[music.SourceSoundCloud] {
+			url = "scsearch:" + url
+		} else {
+			url = "ytsearch:" + url
+		}
+	}
+
+	if !h.checkSource(s, m, sources, music.ClassifyInput(url)) {
+		return
 	}
 
 	msg, _ := s.ChannelMessageSend(m.ChannelID, "Fetching track information...")
 
-	info, err := music.ExtractInfo(url)
+	tracks, err := h.resolvers.Resolve(music.WithGuild(context.Background(), m.GuildID), url)
 	if err != nil {
 		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error: %v", err))
 		return
 	}
-
-	track := &music.Track{
-		Title:     info.Title,
-		URL:       info.URL,
-		Duration:  info.Duration,
-		Thumbnail: info.Thumbnail,
-		Requester: m.Author.ID,
+	for _, track := range tracks {
+		track.Requester = m.Author.ID
 	}
 
+	h.addTracks(s, m, voiceChannel, msg, tracks)
+}
+
+// addTracks queues tracks, reporting the result by editing msg, and starts
+// playback if nothing is playing. It returns whether any track was queued.
+func (h *Handler) addTracks(s *discordgo.Session, m *discordgo.MessageCreate, voiceChannel string, msg *discordgo.Message, tracks []*music.Track) bool {
 	player := h.queueMgr.GetPlayer(m.GuildID)
 
 	if err := player.Connect(s, voiceChannel); err != nil {
 		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error connecting to voice channel: %v", err))
-		return
+		return false
 	}
 
-	if err := h.queueMgr.AddTrack(m.GuildID, voiceChannel, m.Author.ID, track); err != nil {
-		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error adding track: %v", err))
-		return
+	added := 0
+	for _, track := range tracks {
+		err := h.queueMgr.AddTrack(m.GuildID, voiceChannel, m.Author.ID, track)
+		if errors.Is(err, queue.ErrQueueFull) && added > 0 {
+			break
+		}
+		if err != nil {
+			s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error adding track: %v", err))
+			return false
+		}
+		added++
 	}
 
-	s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Added to queue: **%s**", info.Title))
+	switch {
+	case added < len(tracks):
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Added %d tracks to queue. The queue is full! Skipped the remaining %d tracks.", added, len(tracks)-added))
+	case added > 1:
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Added %d tracks to queue", added))
+	case tracks[0].IsLive:
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Added to queue: **%s** (live stream, plays until skipped)", tracks[0].Title))
+	default:
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Added to queue: **%s**", tracks[0].Title))
+	}
 
 	if !player.IsPlaying() {
 		player.Play()
 	}
+	return true
+}
+
+// formatDuration renders seconds as m:ss, or h:mm:ss for long tracks.
+func formatDuration(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// formatTrackLength renders a duration suffix for track listings, or nothing
+// when the length is unknown.
+func formatTrackLength(seconds int) string {
+	if seconds <= 0 {
+		return ""
+	}
+	return " `" + formatDuration(seconds) + "`"
 }
 
 func (h *Handler) handleSkip(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -229,18 +490,24 @@ func (h *Handler) handleSkip(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	if !perm.CanSkip(userLevel) {
+	player := h.queueMgr.GetPlayer(m.GuildID)
+
+	nowPlaying := player.NowPlaying()
+	ownsTrack := nowPlaying != nil && nowPlaying.Requester == m.Author.ID
+
+	if !perm.CanSkipTrack(userLevel, ownsTrack) {
+		h.audit(s, m, "skip", nil, auditDenied)
 		s.ChannelMessageSend(m.ChannelID, "You don't have permission to skip!")
 		return
 	}
 
-	player := h.queueMgr.GetPlayer(m.GuildID)
-
 	if err := player.Skip(); err != nil {
+		h.audit(s, m, "skip", nil, auditError(err))
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
 		return
 	}
 
+	h.audit(s, m, "skip", nil, auditSuccess)
 	s.ChannelMessageSend(m.ChannelID, "Skipped current track!")
 }
 
@@ -253,6 +520,7 @@ func (h *Handler) handleStop(s *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 
 	if !perm.CanManageQueue(userLevel) {
+		h.audit(s, m, "stop", nil, auditDenied)
 		s.ChannelMessageSend(m.ChannelID, "You don't have permission to stop playback!")
 		return
 	}
@@ -261,6 +529,7 @@ func (h *Handler) handleStop(s *discordgo.Session, m *discordgo.MessageCreate) {
 	player.Stop()
 	h.queueMgr.ClearQueue(m.GuildID)
 
+	h.audit(s, m, "stop", nil, auditSuccess)
 	s.ChannelMessageSend(m.ChannelID, "Stopped playback and cleared queue!")
 }
 
@@ -339,7 +608,11 @@ func (h *Handler) handleQueue(s *discordgo.Session, m *discordgo.MessageCreate)
 			queueText += fmt.Sprintf("\n...and %d more tracks", len(queue)-10)
 			break
 		}
-		queueText += fmt.Sprintf("%d. **%s**\n   Requested by <@%s>\n", i+1, track.Title, track.Requester)
+		length := formatTrackLength(track.Duration)
+		if track.IsLive {
+			length = " `LIVE`"
+		}
+		queueText += fmt.Sprintf("%d. **%s**%s\n   Requested by <@%s>\n", i+1, track.Title, length, track.Requester)
 	}
 
 	if queueText != "" {
@@ -375,38 +648,25 @@ func (h *Handler) handleNowPlaying(s *discordgo.Session, m *discordgo.MessageCre
 		},
 	}
 
+	if nowPlaying.Duration > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Length",
+			Value:  formatDuration(nowPlaying.Duration),
+			Inline: true,
+		})
+	}
+
+	if nowPlaying.IsLive {
+		h.sendLiveNowPlaying(s, m, player, nowPlaying, embed)
+		return
+	}
+
 	// Handle album art for local files
 	if nowPlaying.IsLocal && strings.HasPrefix(nowPlaying.Thumbnail, "attachment://") {
-		// Extract the actual file path from the library
-		// We need to get the original file info to access the album art path
-		if h.library != nil {
-			// Find the file in the library to get the actual album art path
-			allFiles := h.library.GetAllFiles()
-			for _, file := range allFiles {
-				if file.Path == nowPlaying.URL {
-					if file.AlbumArt != "" {
-						// Read the album art file
-						artData, err := os.ReadFile(file.AlbumArt)
-						if err == nil {
-							// Send as message with embed and file attachment
-							fileName := filepath.Base(file.AlbumArt)
-							embed.Image = &discordgo.MessageEmbedImage{
-								URL: "attachment://" + fileName,
-							}
-
-							s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-								Embed: embed,
-								Files: []*discordgo.File{
-									{
-										Name:   fileName,
-										Reader: bytes.NewReader(artData),
-									},
-								},
-							})
-							return
-						}
-					}
-					break
+		if library := h.libraries.ForGuild(m.GuildID); library != nil {
+			if file := library.FileByPath(nowPlaying.URL, nowPlaying.Start); file != nil && file.AlbumArt != "" {
+				if h.sendEmbedWithArt(s, m.ChannelID, embed, file.AlbumArt) {
+					return
 				}
 			}
 		}
@@ -433,20 +693,36 @@ func (h *Handler) handleRemove(s *discordgo.Session, m *discordgo.MessageCreate,
 		return
 	}
 
-	if !perm.CanRemoveMusic(userLevel) {
-		s.ChannelMessageSend(m.ChannelID, "You don't have permission to remove tracks!")
-		return
-	}
-
 	var position int
 	fmt.Sscanf(args[0], "%d", &position)
 	position--
 
-	if err := h.queueMgr.RemoveTrack(m.GuildID, position); err != nil {
+	// Users without DJ rights may only remove their own tracks, which is
+	// checked as part of the removal so the queue can't shift in between
+	ownTracksOnly := !perm.CanRemoveTrack(userLevel, false)
+	if ownTracksOnly && !perm.CanRemoveTrack(userLevel, true) {
+		h.audit(s, m, "remove", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to remove tracks!")
+		return
+	}
+
+	if ownTracksOnly {
+		err = h.queueMgr.RemoveTrackIfOwned(m.GuildID, position, m.Author.ID)
+	} else {
+		err = h.queueMgr.RemoveTrack(m.GuildID, position)
+	}
+	if errors.Is(err, music.ErrNotOwner) {
+		h.audit(s, m, "remove", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to remove tracks!")
+		return
+	}
+	if err != nil {
+		h.audit(s, m, "remove", args, auditError(err))
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
 		return
 	}
 
+	h.audit(s, m, "remove", args, auditSuccess)
 	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Removed track at position %d", position+1))
 }
 
@@ -459,15 +735,18 @@ func (h *Handler) handleClear(s *discordgo.Session, m *discordgo.MessageCreate)
 	}
 
 	if !perm.CanManageQueue(userLevel) {
+		h.audit(s, m, "clear", nil, auditDenied)
 		s.ChannelMessageSend(m.ChannelID, "You don't have permission to clear the queue!")
 		return
 	}
 
 	if err := h.queueMgr.ClearQueue(m.GuildID); err != nil {
+		h.audit(s, m, "clear", nil, auditError(err))
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
 		return
 	}
 
+	h.audit(s, m, "clear", nil, auditSuccess)
 	s.ChannelMessageSend(m.ChannelID, "Queue cleared!")
 }
 
@@ -485,6 +764,7 @@ func (h *Handler) handleMoveTop(s *discordgo.Session, m *discordgo.MessageCreate
 	}
 
 	if !perm.CanMoveToTop(userLevel) {
+		h.audit(s, m, "movetop", args, auditDenied)
 		s.ChannelMessageSend(m.ChannelID, "You don't have permission to move tracks!")
 		return
 	}
@@ -494,13 +774,61 @@ func (h *Handler) handleMoveTop(s *discordgo.Session, m *discordgo.MessageCreate
 	position--
 
 	if err := h.queueMgr.MoveToTop(m.GuildID, position); err != nil {
+		h.audit(s, m, "movetop", args, auditError(err))
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
 		return
 	}
 
+	h.audit(s, m, "movetop", args, auditSuccess)
 	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Moved track at position %d to top of queue", position+1))
 }
 
+func (h *Handler) handleMove(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!move <from> <to>`")
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	var from, to int
+	fmt.Sscanf(args[0], "%d", &from)
+	fmt.Sscanf(args[1], "%d", &to)
+	from--
+	to--
+
+	ownTracksOnly := !perm.CanMoveTrack(userLevel, false, from, to)
+	if ownTracksOnly && !perm.CanMoveTrack(userLevel, true, from, to) {
+		h.audit(s, m, "move", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to move that track there!")
+		return
+	}
+
+	if ownTracksOnly {
+		err = h.queueMgr.MoveTrackIfOwned(m.GuildID, from, to, m.Author.ID)
+	} else {
+		err = h.queueMgr.MoveTrack(m.GuildID, from, to)
+	}
+	if errors.Is(err, music.ErrNotOwner) {
+		h.audit(s, m, "move", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to move that track there!")
+		return
+	}
+	if err != nil {
+		h.audit(s, m, "move", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	h.audit(s, m, "move", args, auditSuccess)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Moved track from position %d to %d", from+1, to+1))
+}
+
 func (h *Handler) handleVolume(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
 	if len(args) == 0 {
 		s.ChannelMessageSend(m.ChannelID, "Please specify a volume (0-100)!")
@@ -515,6 +843,7 @@ func (h *Handler) handleVolume(s *discordgo.Session, m *discordgo.MessageCreate,
 	}
 
 	if !perm.CanSkip(userLevel) {
+		h.audit(s, m, "volume", args, auditDenied)
 		s.ChannelMessageSend(m.ChannelID, "You don't have permission to change volume!")
 		return
 	}
@@ -525,12 +854,14 @@ func (h *Handler) handleVolume(s *discordgo.Session, m *discordgo.MessageCreate,
 	player := h.queueMgr.GetPlayer(m.GuildID)
 
 	if err := player.SetVolume(volume); err != nil {
+		h.audit(s, m, "volume", args, auditError(err))
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
 		return
 	}
 
 	h.db.UpdateGuildVolume(m.GuildID, volume)
 
+	h.audit(s, m, "volume", args, auditSuccess)
 	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Volume set to %d%%", volume))
 }
 
@@ -541,6 +872,11 @@ func (h *Handler) handleJoin(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
+	if !h.voiceChannelAllowed(m.GuildID, voiceChannel) {
+		s.ChannelMessageSend(m.ChannelID, "I'm not allowed to join that voice channel!")
+		return
+	}
+
 	player := h.queueMgr.GetPlayer(m.GuildID)
 
 	if err := player.Connect(s, voiceChannel); err != nil {
@@ -555,12 +891,14 @@ func (h *Handler) handleLeave(s *discordgo.Session, m *discordgo.MessageCreate)
 	player := h.queueMgr.GetPlayer(m.GuildID)
 
 	if err := player.Disconnect(); err != nil {
+		h.audit(s, m, "leave", nil, auditError(err))
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
 		return
 	}
 
 	h.queueMgr.RemovePlayer(m.GuildID)
 
+	h.audit(s, m, "leave", nil, auditSuccess)
 	s.ChannelMessageSend(m.ChannelID, "Left voice channel!")
 }
 
@@ -572,16 +910,20 @@ func (h *Handler) handleHelp(s *discordgo.Session, m *discordgo.MessageCreate) {
 		Fields: []*discordgo.MessageEmbedField{
 			{
 				Name: "Music Commands",
-				Value: "`!play <url/query>` - Play a song\n" +
-					"`!skip` - Skip current song (DJ+)\n" +
+				Value: "`!play <url/query>` - Play a song (or attach an audio file)\n" +
+					"`!playfile` - Reply to a message to play its audio files\n" +
+					"`!radio list/play/add/remove` - Radio station favorites\n" +
+					"`!podcast list/episodes/play/add/remove` - Podcasts, resumed where you left off\n" +
+					"`!skip` - Skip current song (DJ+ or requester)\n" +
 					"`!stop` - Stop playback (Mod+)\n" +
 					"`!pause` - Pause playback (DJ+)\n" +
 					"`!resume` - Resume playback (DJ+)\n" +
 					"`!queue` - Show queue\n" +
 					"`!nowplaying` - Show current song\n" +
-					"`!remove <position>` - Remove song (DJ+)\n" +
+					"`!remove <position>` - Remove song (DJ+ or requester)\n" +
 					"`!clear` - Clear queue (Mod+)\n" +
 					"`!movetop <position>` - Move song to top (DJ+)\n" +
+					"`!move <from> <to>` - Move song (DJ+, requesters can move theirs down)\n" +
 					"`!volume <0-100>` - Set volume (DJ+)",
 				Inline: false,
 			},
@@ -589,22 +931,44 @@ func (h *Handler) handleHelp(s *discordgo.Session, m *discordgo.MessageCreate) {
 				Name: "Local Files",
 				Value: "`!folders` - List all music folders\n" +
 					"`!files <folder>` - List files in a folder\n" +
-					"`!local <folder> <filename>` - Play local file\n" +
-					"`!search <query>` - Search for files by name",
+					"`!local [folder] <filename>` - Play local file\n" +
+					"`!search <query>` - Fuzzy search by title, artist, album or name\n" +
+					"`!pick <number>` - Play a search result or choice\n" +
+					"`!localfolder <folder> [--recursive] [--shuffle] [--sort name|track|date]` - Queue a folder\n" +
+					"`!localplaylist [name]` - List or queue .m3u/.pls playlists\n" +
+					"`!artists` / `!albums <artist>` - Browse artists and their albums\n" +
+					"`!album <name>` - Show an album's tracks\n" +
+					"`!playalbum <name>` - Queue a whole album in track order\n" +
+					"`!genre [name]` / `!year <year>` - Browse by genre or year\n" +
+					"`!rescan` - Rescan the music folder (Admin)",
 				Inline: false,
 			},
 			{
 				Name: "Bot Commands",
 				Value: "`!join` - Join voice channel\n" +
 					"`!leave` - Leave voice channel\n" +
-					"`!setrole <dj/mod> <@role>` - Set roles (Admin)\n" +
 					"`!source` - Show source code and creator info\n" +
 					"`!help` - Show this message",
 				Inline: false,
 			},
 			{
-				Name: "Supported Sources",
-				Value: "YouTube, SoundCloud, Bandcamp, Vimeo, Twitch, Local files, HTTP URLs",
+				Name: "Server Management",
+				Value: "`!setrole <dj/mod> <@role>` - Add a DJ/Mod role (Admin)\n" +
+					"`!setrole djabove <@role/off>` - Roles at or above count as DJ (Admin)\n" +
+					"`!removerole <dj/mod> <@role>` - Remove a DJ/Mod role (Admin)\n" +
+					"`!roles` - Show permission roles\n" +
+					"`!channels allow/deny/reset <#channel>` - Restrict channels (Admin)\n" +
+					"`!settings [name] [value]` - View or change settings (Admin)\n" +
+					"`!sources [enable/disable <source>]` - Switch sources on or off (Admin)\n" +
+					"`!botban @user [duration] [reason] [--purge]` - Ban from the bot (Mod+)\n" +
+					"`!botunban @user` - Lift a bot ban (Mod+)\n" +
+					"`!botbans` - List bot bans (Mod+)\n" +
+					"`!audit [@user] [command]` - Show privileged actions (Mod+)",
+				Inline: false,
+			},
+			{
+				Name:   "Supported Sources",
+				Value:  sourceList(h.guildSources(m.GuildID)),
 				Inline: false,
 			},
 		},
@@ -650,7 +1014,7 @@ func (h *Handler) handleSource(s *discordgo.Session, m *discordgo.MessageCreate)
 
 func (h *Handler) handleSetRole(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
 	if len(args) < 2 {
-		s.ChannelMessageSend(m.ChannelID, "Usage: !setrole <dj/mod> <@role>")
+		s.ChannelMessageSend(m.ChannelID, "Usage: !setrole <dj/mod> <@role> or !setrole djabove <@role/off>")
 		return
 	}
 
@@ -662,6 +1026,7 @@ func (h *Handler) handleSetRole(s *discordgo.Session, m *discordgo.MessageCreate
 	}
 
 	if !perm.CanChangeSettings(userLevel) {
+		h.audit(s, m, "setrole", args, auditDenied)
 		s.ChannelMessageSend(m.ChannelID, "You don't have permission to change settings!")
 		return
 	}
@@ -669,49 +1034,176 @@ func (h *Handler) handleSetRole(s *discordgo.Session, m *discordgo.MessageCreate
 	roleType := strings.ToLower(args[0])
 	roleID := strings.Trim(args[1], "<@&>")
 
+	if roleType == "djabove" {
+		if strings.ToLower(args[1]) == "off" {
+			roleID = ""
+		}
+
+		if err := h.db.UpdateGuildDJAboveRole(m.GuildID, roleID); err != nil {
+			h.audit(s, m, "setrole", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error updating roles!")
+			return
+		}
+
+		if err := h.reloadPermission(m.GuildID); err != nil {
+			h.audit(s, m, "setrole", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error reloading roles!")
+			return
+		}
+
+		if roleID == "" {
+			h.audit(s, m, "setrole", args, auditSuccess)
+			s.ChannelMessageSend(m.ChannelID, "Role hierarchy DJ rule disabled")
+			return
+		}
+
+		h.audit(s, m, "setrole", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Every role at or above <@&%s> now counts as DJ", roleID))
+		return
+	}
+
+	level, ok := roleLevelFromArg(roleType)
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, "Invalid role type! Use 'dj', 'mod' or 'djabove'")
+		return
+	}
+
+	if err := h.db.AddGuildRole(m.GuildID, roleID, level); err != nil {
+		h.audit(s, m, "setrole", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "Error updating roles!")
+		return
+	}
+
+	if err := h.reloadPermission(m.GuildID); err != nil {
+		h.audit(s, m, "setrole", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "Error reloading roles!")
+		return
+	}
+
+	h.audit(s, m, "setrole", args, auditSuccess)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added <@&%s> as a %s role", roleID, roleType))
+}
+
+func (h *Handler) handleRemoveRole(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: !removerole <dj/mod> <@role>")
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanChangeSettings(userLevel) {
+		h.audit(s, m, "removerole", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to change settings!")
+		return
+	}
+
+	roleType := strings.ToLower(args[0])
+	roleID := strings.Trim(args[1], "<@&>")
+
+	level, ok := roleLevelFromArg(roleType)
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, "Invalid role type! Use 'dj' or 'mod'")
+		return
+	}
+
+	removed, err := h.db.RemoveGuildRole(m.GuildID, roleID, level)
+	if err != nil {
+		h.audit(s, m, "removerole", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "Error updating roles!")
+		return
+	}
+
+	if !removed {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@&%s> is not a %s role", roleID, roleType))
+		return
+	}
+
+	if err := h.reloadPermission(m.GuildID); err != nil {
+		h.audit(s, m, "removerole", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "Error reloading roles!")
+		return
+	}
+
+	h.audit(s, m, "removerole", args, auditSuccess)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Removed <@&%s> from %s roles", roleID, roleType))
+}
+
+func (h *Handler) handleRoles(s *discordgo.Session, m *discordgo.MessageCreate) {
 	guild, err := h.db.GetGuild(m.GuildID)
 	if err != nil {
 		s.ChannelMessageSend(m.ChannelID, "Error getting guild settings!")
 		return
 	}
 
-	djRoleID := ""
-	modRoleID := ""
+	djRoles, modRoles, err := h.loadRoles(m.GuildID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error getting guild settings!")
+		return
+	}
 
-	if guild.DJRoleID.Valid {
-		djRoleID = guild.DJRoleID.String
+	embed := &discordgo.MessageEmbed{
+		Title: "Permission Roles",
+		Color: 0x9B59B6,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Mod Roles",
+				Value:  formatRoleList(modRoles),
+				Inline: true,
+			},
+			{
+				Name:   "DJ Roles",
+				Value:  formatRoleList(djRoles),
+				Inline: true,
+			},
+		},
 	}
-	if guild.ModRoleID.Valid {
-		modRoleID = guild.ModRoleID.String
+
+	if guild.DJAboveRoleID.Valid {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Role Hierarchy",
+			Value:  fmt.Sprintf("Every role at or above <@&%s> counts as DJ", guild.DJAboveRoleID.String),
+			Inline: false,
+		})
 	}
 
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+func roleLevelFromArg(roleType string) (string, bool) {
 	switch roleType {
 	case "dj":
-		djRoleID = roleID
+		return database.RoleLevelDJ, true
 	case "mod", "moderator":
-		modRoleID = roleID
-	default:
-		s.ChannelMessageSend(m.ChannelID, "Invalid role type! Use 'dj' or 'mod'")
-		return
+		return database.RoleLevelMod, true
 	}
+	return "", false
+}
 
-	if err := h.db.UpdateGuildRoles(m.GuildID, djRoleID, modRoleID); err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Error updating roles!")
-		return
+func formatRoleList(roleIDs []string) string {
+	if len(roleIDs) == 0 {
+		return "None"
 	}
 
-	perm.UpdateRoles(djRoleID, modRoleID)
-
-	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Updated %s role to <@&%s>", roleType, roleID))
+	mentions := make([]string, len(roleIDs))
+	for i, roleID := range roleIDs {
+		mentions[i] = fmt.Sprintf("<@&%s>", roleID)
+	}
+	return strings.Join(mentions, "\n")
 }
 
 func (h *Handler) handleFolders(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if h.library == nil {
-		s.ChannelMessageSend(m.ChannelID, "Local library is not configured!")
+	library := h.guildLibrary(s, m)
+	if library == nil {
 		return
 	}
 
-	folders := h.library.GetFolders()
+	folders := library.GetFolders()
 
 	if len(folders) == 0 {
 		s.ChannelMessageSend(m.ChannelID, "No folders found in local library!")
@@ -725,21 +1217,21 @@ func (h *Handler) handleFolders(s *discordgo.Session, m *discordgo.MessageCreate
 
 	foldersList := ""
 	for i, folder := range folders {
-		files := h.library.GetFiles(folder)
+		files := library.GetFiles(folder)
 		foldersList += fmt.Sprintf("%d. **%s** (%d files)\n", i+1, folder, len(files))
 	}
 
 	embed.Description = foldersList
 	embed.Footer = &discordgo.MessageEmbedFooter{
-		Text: fmt.Sprintf("Total: %d files", h.library.GetTotalFiles()),
+		Text: fmt.Sprintf("Total: %d files", library.GetTotalFiles()),
 	}
 
 	s.ChannelMessageSendEmbed(m.ChannelID, embed)
 }
 
 func (h *Handler) handleFiles(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	if h.library == nil {
-		s.ChannelMessageSend(m.ChannelID, "Local library is not configured!")
+	library := h.guildLibrary(s, m)
+	if library == nil {
 		return
 	}
 
@@ -749,7 +1241,7 @@ func (h *Handler) handleFiles(s *discordgo.Session, m *discordgo.MessageCreate,
 	}
 
 	folder := strings.Join(args, " ")
-	files := h.library.GetFiles(folder)
+	files := library.GetFiles(folder)
 
 	if len(files) == 0 {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No files found in folder: %s", folder))
@@ -767,7 +1259,7 @@ func (h *Handler) handleFiles(s *discordgo.Session, m *discordgo.MessageCreate,
 			filesList += fmt.Sprintf("\n...and %d more files", len(files)-20)
 			break
 		}
-		filesList += fmt.Sprintf("%d. %s\n", i+1, file.Name)
+		filesList += fmt.Sprintf("%d. %s%s\n", i+1, file.Name, formatTrackLength(file.Duration))
 	}
 
 	embed.Description = filesList
@@ -779,43 +1271,43 @@ func (h *Handler) handleFiles(s *discordgo.Session, m *discordgo.MessageCreate,
 }
 
 func (h *Handler) handleLocalPlay(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	if h.library == nil {
-		s.ChannelMessageSend(m.ChannelID, "Local library is not configured!")
+	library := h.guildLibrary(s, m)
+	if library == nil {
 		return
 	}
 
-	if len(args) < 2 {
-		s.ChannelMessageSend(m.ChannelID, "Usage: `!local <folder> <filename>`")
-		return
-	}
-
-	perm := h.getPermission(m.GuildID)
-	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
-	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+	if len(args) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!local [folder] <filename>`")
 		return
 	}
 
-	if !perm.CanAddMusic(userLevel) {
-		s.ChannelMessageSend(m.ChannelID, "You don't have permission to add music!")
-		return
+	// Look in the folder if the first word names one, otherwise search the
+	// whole library
+	var file *music.LocalFile
+	var err error
+	query := strings.Join(args, " ")
+	if len(args) >= 2 && library.HasFolder(args[0]) {
+		query = strings.Join(args[1:], " ")
+		file, err = library.GetFileByFolderAndName(args[0], query)
+	} else {
+		file, err = library.FindFile(query)
 	}
 
-	voiceChannel, err := h.getUserVoiceChannel(s, m.GuildID, m.Author.ID)
-	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "You must be in a voice channel!")
+	var ambiguous *music.AmbiguousMatchError
+	if errors.As(err, &ambiguous) {
+		h.offerChoice(s, m, library, fmt.Sprintf("Which one did you mean by \"%s\"?", query), ambiguous.Matches)
 		return
 	}
-
-	folder := args[0]
-	fileName := strings.Join(args[1:], " ")
-
-	file, err := h.library.GetFileByFolderAndName(folder, fileName)
 	if err != nil {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
 		return
 	}
 
+	h.enqueueLocalFiles(s, m, library, []*music.LocalFile{file}, "")
+}
+
+// localTrack builds a queue track for a library file.
+func localTrack(file *music.LocalFile, requester string) *music.Track {
 	// Use metadata title if available, otherwise use filename
 	trackTitle := file.Title
 	if trackTitle == "" {
@@ -829,37 +1321,21 @@ func (h *Handler) handleLocalPlay(s *discordgo.Session, m *discordgo.MessageCrea
 		thumbnail = "attachment://" + filepath.Base(thumbnail)
 	}
 
-	track := &music.Track{
+	return &music.Track{
 		Title:     trackTitle,
 		URL:       file.Path,
 		Duration:  file.Duration,
 		Thumbnail: thumbnail,
-		Requester: m.Author.ID,
+		Requester: requester,
 		IsLocal:   true,
-	}
-
-	player := h.queueMgr.GetPlayer(m.GuildID)
-
-	if err := player.Connect(s, voiceChannel); err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error connecting to voice channel: %v", err))
-		return
-	}
-
-	if err := h.queueMgr.AddTrack(m.GuildID, voiceChannel, m.Author.ID, track); err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error adding track: %v", err))
-		return
-	}
-
-	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added to queue: **%s** (from %s)", file.Name, folder))
-
-	if !player.IsPlaying() {
-		player.Play()
+		Start:     file.Start,
+		End:       file.End,
 	}
 }
 
 func (h *Handler) handleSearch(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
-	if h.library == nil {
-		s.ChannelMessageSend(m.ChannelID, "Local library is not configured!")
+	library := h.guildLibrary(s, m)
+	if library == nil {
 		return
 	}
 
@@ -869,7 +1345,7 @@ func (h *Handler) handleSearch(s *discordgo.Session, m *discordgo.MessageCreate,
 	}
 
 	query := strings.Join(args, " ")
-	results := h.library.SearchByName(query)
+	results := library.SearchByName(query)
 
 	if len(results) == 0 {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No files found matching: %s", query))
@@ -887,13 +1363,14 @@ func (h *Handler) handleSearch(s *discordgo.Session, m *discordgo.MessageCreate,
 			resultsList += fmt.Sprintf("\n...and %d more results", len(results)-15)
 			break
 		}
-		resultsList += fmt.Sprintf("%d. **%s** (in %s)\n", i+1, file.Name, file.Folder)
+		resultsList += fmt.Sprintf("%d. **%s** (in %s)\n", i+1, file.Name, library.Location(file))
 	}
 
 	embed.Description = resultsList
 	embed.Footer = &discordgo.MessageEmbedFooter{
-		Text: fmt.Sprintf("Total: %d results", len(results)),
+		Text: fmt.Sprintf("Total: %d results, best matches first • Use !pick <number> to play one", len(results)),
 	}
 
+	h.setChoice(m.GuildID, m.Author.ID, results[:min(len(results), 15)])
 	s.ChannelMessageSendEmbed(m.ChannelID, embed)
 }
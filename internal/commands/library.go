@@ -0,0 +1,137 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// progressInterval throttles progress message edits to stay clear of
+// Discord's rate limits.
+const progressInterval = 3 * time.Second
+
+// guildLibrary returns the library roots the guild can see, telling the
+// user when there are none.
+func (h *Handler) guildLibrary(s *discordgo.Session, m *discordgo.MessageCreate) *music.GuildLibrary {
+	library := h.libraries.ForGuild(m.GuildID)
+	if library == nil {
+		s.ChannelMessageSend(m.ChannelID, "Local library is not configured!")
+	}
+	return library
+}
+
+// handlePlayPath plays a file given by path with !play. The path must
+// resolve, symlinks and all, to a file inside one of the guild's library
+// roots; anything else is refused and written to the audit log.
+func (h *Handler) handlePlayPath(s *discordgo.Session, m *discordgo.MessageCreate, args []string, path string) {
+	library := h.libraries.ForGuild(m.GuildID)
+	if library == nil {
+		h.audit(s, m, "play", args, auditError(fmt.Errorf("%w: %s", music.ErrOutsideLibrary, path)))
+		s.ChannelMessageSend(m.ChannelID, "You can only play files from the music library!")
+		return
+	}
+
+	file, err := library.FileAtPath(path)
+	if errors.Is(err, music.ErrOutsideLibrary) {
+		h.audit(s, m, "play", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "You can only play files from the music library!")
+		return
+	}
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "That file isn't in the music library! Try !rescan if it was just added.")
+		return
+	}
+
+	h.enqueueLocalFiles(s, m, library, []*music.LocalFile{file}, library.Location(file))
+}
+
+// checkLocalFiles drops files whose path no longer resolves inside the
+// guild's library roots, such as a symlink repointed after the last scan,
+// and audits each one it drops.
+func (h *Handler) checkLocalFiles(s *discordgo.Session, m *discordgo.MessageCreate, library *music.GuildLibrary, files []*music.LocalFile) []*music.LocalFile {
+	allowed := files[:0:0]
+	for _, file := range files {
+		if _, err := library.ResolvePath(file.Path); err != nil {
+			h.audit(s, m, "play", []string{file.Path}, auditError(err))
+			continue
+		}
+		allowed = append(allowed, file)
+	}
+	return allowed
+}
+
+func (h *Handler) handleRescan(s *discordgo.Session, m *discordgo.MessageCreate) {
+	library := h.guildLibrary(s, m)
+	if library == nil {
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanChangeSettings(userLevel) {
+		h.audit(s, m, "rescan", nil, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to rescan the library!")
+		return
+	}
+
+	msg, err := s.ChannelMessageSend(m.ChannelID, "Scanning music folder...")
+	if err != nil {
+		return
+	}
+
+	var mu sync.Mutex
+	lastUpdate := time.Now()
+	result, err := library.Scan(context.Background(), func(p music.ScanProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if time.Since(lastUpdate) < progressInterval && p.Processed != p.Changed {
+			return
+		}
+		lastUpdate = time.Now()
+
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Scanning music folder... %d files found, reading tags for %d/%d new or changed files",
+			p.Total, p.Processed, p.Changed))
+	})
+	if err != nil {
+		h.audit(s, m, "rescan", nil, auditError(err))
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	h.audit(s, m, "rescan", nil, auditSuccess)
+	s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Rescan complete in %s: %d files (%d added, %d updated, %d removed)",
+		result.Elapsed.Round(time.Millisecond), result.Total, result.Added, result.Updated, result.Removed))
+}
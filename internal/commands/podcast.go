@@ -0,0 +1,380 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"miku_bot/internal/database"
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// episodeLimit is how many episodes !podcast episodes lists.
+	episodeLimit = 10
+
+	// resumeRewind replays a few seconds before the saved position so the
+	// listeners can pick the thread back up.
+	resumeRewind = 5 * time.Second
+
+	// nearlyFinished treats episodes stopped this close to the end as
+	// played, so they start over instead of resuming into the outro.
+	nearlyFinished = 30 * time.Second
+)
+
+func (h *Handler) handlePodcast(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) == 0 || strings.ToLower(args[0]) == "list" {
+		h.listPodcasts(s, m)
+		return
+	}
+
+	action := strings.ToLower(args[0])
+	switch action {
+	case "episodes", "eps":
+		if len(args) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!podcast episodes <name>`")
+			return
+		}
+		h.listEpisodes(s, m, args[1])
+		return
+	case "play":
+		if len(args) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!podcast play <name> [episode]`")
+			return
+		}
+		h.playEpisode(s, m, args[1], strings.Join(args[2:], " "))
+		return
+	case "add", "remove", "rm":
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!podcast list`, `!podcast episodes <name>`, `!podcast play <name> [episode]`, `!podcast add <rss-url> [name]` or `!podcast remove <name>`")
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanManagePodcasts(userLevel) {
+		h.audit(s, m, "podcast", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to manage podcasts!")
+		return
+	}
+
+	if action != "add" {
+		if len(args) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!podcast remove <name>`")
+			return
+		}
+
+		removed, err := h.db.RemovePodcast(m.GuildID, args[1])
+		if err != nil {
+			h.audit(s, m, "podcast", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error removing podcast!")
+			return
+		}
+		if !removed {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No podcast named **%s**!", args[1]))
+			return
+		}
+		h.audit(s, m, "podcast", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Unsubscribed from **%s**", strings.ToLower(args[1])))
+		return
+	}
+
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!podcast add <rss-url> [name]`")
+		return
+	}
+
+	url := args[1]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		s.ChannelMessageSend(m.ChannelID, "Podcast feeds need an http:// or https:// URL!")
+		return
+	}
+
+	msg, _ := s.ChannelMessageSend(m.ChannelID, "Fetching feed...")
+
+	feed, err := music.FetchFeed(context.Background(), h.hosts, url)
+	if err != nil {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	if len(feed.Episodes) == 0 {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, "That feed has no audio episodes!")
+		return
+	}
+
+	name := podcastName(feed.Title)
+	if len(args) > 2 {
+		name = strings.ToLower(args[2])
+	}
+
+	podcast := &database.Podcast{
+		GuildID: m.GuildID,
+		Name:    name,
+		URL:     url,
+		Title:   feed.Title,
+		AddedBy: m.Author.ID,
+	}
+	if err := h.db.AddPodcast(podcast); err != nil {
+		h.audit(s, m, "podcast", args, auditError(err))
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, "Error saving podcast!")
+		return
+	}
+
+	h.audit(s, m, "podcast", args, auditSuccess)
+	s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Subscribed to **%s** (%d episodes) as `%s`! See them with `!podcast episodes %s`", feed.Title, len(feed.Episodes), name, name))
+}
+
+// podcastName turns a feed title into a one-word name for commands, such
+// as "the-daily" for "The Daily".
+func podcastName(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+		if b.Len() >= 32 {
+			break
+		}
+	}
+
+	name := strings.Trim(b.String(), "-")
+	if name == "" {
+		return "podcast"
+	}
+	return name
+}
+
+func (h *Handler) listPodcasts(s *discordgo.Session, m *discordgo.MessageCreate) {
+	podcasts, err := h.db.GetPodcasts(m.GuildID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error loading podcasts!")
+		return
+	}
+
+	if len(podcasts) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "No podcasts subscribed! Add one with `!podcast add <rss-url>`")
+		return
+	}
+
+	list := ""
+	for _, podcast := range podcasts {
+		list += fmt.Sprintf("`%s` - %s\n", podcast.Name, podcast.Title)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Podcasts",
+		Description: list,
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Use !podcast episodes <name> to see episodes",
+		},
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+// loadFeed fetches a subscribed podcast's feed, telling the user what went
+// wrong if it can't.
+func (h *Handler) loadFeed(s *discordgo.Session, m *discordgo.MessageCreate, name string) (*database.Podcast, *music.Feed) {
+	podcast, err := h.db.GetPodcast(m.GuildID, name)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error loading podcasts!")
+		return nil, nil
+	}
+	if podcast == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No podcast named **%s**! See `!podcast list`", name))
+		return nil, nil
+	}
+
+	feed, err := music.FetchFeed(context.Background(), h.hosts, podcast.URL)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
+		return nil, nil
+	}
+	if len(feed.Episodes) == 0 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("**%s** has no audio episodes!", podcast.Title))
+		return nil, nil
+	}
+
+	return podcast, feed
+}
+
+func (h *Handler) listEpisodes(s *discordgo.Session, m *discordgo.MessageCreate, name string) {
+	podcast, feed := h.loadFeed(s, m, name)
+	if feed == nil {
+		return
+	}
+
+	list := ""
+	for i, episode := range feed.Episodes {
+		if i >= episodeLimit {
+			list += fmt.Sprintf("\n...and %d older episodes", len(feed.Episodes)-episodeLimit)
+			break
+		}
+
+		status := ""
+		if progress, err := h.db.GetEpisodeProgress(m.GuildID, episode.ID); err == nil && progress != nil {
+			if progress.Finished {
+				status = " ✅"
+			} else {
+				status = fmt.Sprintf(" ⏸️ %s", formatDuration(int(progress.Position.Seconds())))
+			}
+		}
+
+		date := ""
+		if !episode.Published.IsZero() {
+			date = episode.Published.Format("2006-01-02") + " "
+		}
+		list += fmt.Sprintf("%d. %s**%s**%s%s\n", i+1, date, episode.Title, formatTrackLength(episode.Duration), status)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       feed.Title,
+		Description: list,
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Use !podcast play %s <number> to listen", podcast.Name),
+		},
+	}
+	if feed.Image != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: feed.Image}
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+// findEpisode picks an episode by number, 1 being the newest, or by a
+// case-insensitive match on its title. An empty query picks the newest.
+func findEpisode(episodes []*music.Episode, query string) *music.Episode {
+	if query == "" {
+		return episodes[0]
+	}
+
+	if n, err := strconv.Atoi(query); err == nil {
+		if n < 1 || n > len(episodes) {
+			return nil
+		}
+		return episodes[n-1]
+	}
+
+	query = strings.ToLower(query)
+	for _, episode := range episodes {
+		if strings.Contains(strings.ToLower(episode.Title), query) {
+			return episode
+		}
+	}
+	return nil
+}
+
+func (h *Handler) playEpisode(s *discordgo.Session, m *discordgo.MessageCreate, name, query string) {
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanAddMusic(userLevel) {
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to add music!")
+		return
+	}
+
+	voiceChannel, err := h.getUserVoiceChannel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "You must be in a voice channel!")
+		return
+	}
+
+	if !h.voiceChannelAllowed(m.GuildID, voiceChannel) {
+		s.ChannelMessageSend(m.ChannelID, "I'm not allowed to join that voice channel!")
+		return
+	}
+
+	if !h.checkSource(s, m, h.guildSources(m.GuildID), music.SourceHTTP) {
+		return
+	}
+
+	_, feed := h.loadFeed(s, m, name)
+	if feed == nil {
+		return
+	}
+
+	episode := findEpisode(feed.Episodes, query)
+	if episode == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No episode matching **%s**! See `!podcast episodes %s`", query, name))
+		return
+	}
+
+	// Resume where the guild left off, unless it finished the episode
+	var start time.Duration
+	progress, err := h.db.GetEpisodeProgress(m.GuildID, episode.ID)
+	if err != nil {
+		log.Printf("Failed to load progress for episode %s in guild %s: %v", episode.ID, m.GuildID, err)
+	}
+	if progress != nil && !progress.Finished {
+		start = max(progress.Position-resumeRewind, 0)
+	}
+
+	track := episode.Track(start, m.Author.ID)
+	guildID := m.GuildID
+	track.SavePosition = func(position time.Duration, finished bool) {
+		if episode.Duration > 0 && position >= time.Duration(episode.Duration)*time.Second-nearlyFinished {
+			finished = true
+		}
+		if finished {
+			position = 0
+		}
+		err := h.db.SaveEpisodeProgress(&database.EpisodeProgress{
+			GuildID:   guildID,
+			EpisodeID: episode.ID,
+			Position:  position,
+			Finished:  finished,
+		})
+		if err != nil {
+			log.Printf("Failed to save progress for episode %s in guild %s: %v", episode.ID, guildID, err)
+		}
+	}
+
+	msg, _ := s.ChannelMessageSend(m.ChannelID, "Fetching track information...")
+
+	if h.addTracks(s, m, voiceChannel, msg, []*music.Track{track}) && start > 0 {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Added to queue: **%s** (resuming at %s)", track.Title, formatDuration(int(start.Seconds()))))
+	}
+}
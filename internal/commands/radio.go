@@ -0,0 +1,310 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"miku_bot/internal/database"
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// streamTitleInterval is how often a live now-playing embed checks for
+	// a new StreamTitle, and streamTitleFollow how long it keeps checking.
+	streamTitleInterval = 15 * time.Second
+	streamTitleFollow   = 30 * time.Minute
+)
+
+func (h *Handler) handleRadio(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) == 0 || strings.ToLower(args[0]) == "list" {
+		h.listRadioStations(s, m)
+		return
+	}
+
+	action := strings.ToLower(args[0])
+	switch action {
+	case "play":
+		if len(args) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!radio play <name>`")
+			return
+		}
+		h.playRadioStation(s, m, args[1])
+		return
+	case "add", "remove", "rm":
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!radio list`, `!radio play <name>`, `!radio add <name> <url>` or `!radio remove <name>`")
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanManageStations(userLevel) {
+		h.audit(s, m, "radio", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to manage radio stations!")
+		return
+	}
+
+	if action != "add" {
+		if len(args) < 2 {
+			s.ChannelMessageSend(m.ChannelID, "Usage: `!radio remove <name>`")
+			return
+		}
+
+		removed, err := h.db.RemoveRadioStation(m.GuildID, args[1])
+		if err != nil {
+			h.audit(s, m, "radio", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error removing radio station!")
+			return
+		}
+		if !removed {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No radio station named **%s**!", args[1]))
+			return
+		}
+		h.audit(s, m, "radio", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Removed radio station **%s**", strings.ToLower(args[1])))
+		return
+	}
+
+	if len(args) < 3 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!radio add <name> <url>`")
+		return
+	}
+
+	name, url := strings.ToLower(args[1]), args[2]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		s.ChannelMessageSend(m.ChannelID, "Radio stations need an http:// or https:// URL!")
+		return
+	}
+
+	msg, _ := s.ChannelMessageSend(m.ChannelID, "Checking stream...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), music.ProbeTimeout)
+	defer cancel()
+
+	stream, err := music.ProbeStream(ctx, h.hosts, url)
+	if err != nil {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	if stream == nil {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, "That URL isn't a live stream! Use `!play` for regular tracks.")
+		return
+	}
+
+	station := &database.RadioStation{
+		GuildID: m.GuildID,
+		Name:    name,
+		URL:     url,
+		AddedBy: m.Author.ID,
+	}
+	if err := h.db.AddRadioStation(station); err != nil {
+		h.audit(s, m, "radio", args, auditError(err))
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, "Error saving radio station!")
+		return
+	}
+
+	h.audit(s, m, "radio", args, auditSuccess)
+	if stream.Name != "" {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Saved radio station **%s** (%s)! Play it with `!radio play %s`", name, stream.Name, name))
+	} else {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Saved radio station **%s**! Play it with `!radio play %s`", name, name))
+	}
+}
+
+func (h *Handler) listRadioStations(s *discordgo.Session, m *discordgo.MessageCreate) {
+	stations, err := h.db.GetRadioStations(m.GuildID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error loading radio stations!")
+		return
+	}
+
+	if len(stations) == 0 {
+		s.ChannelMessageSend(m.ChannelID, "No radio stations saved! Add one with `!radio add <name> <url>`")
+		return
+	}
+
+	list := ""
+	for _, station := range stations {
+		list += fmt.Sprintf("**%s** - %s\n", station.Name, station.URL)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Radio Stations",
+		Description: list,
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Use !radio play <name> to tune in",
+		},
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
+
+func (h *Handler) playRadioStation(s *discordgo.Session, m *discordgo.MessageCreate, name string) {
+	station, err := h.db.GetRadioStation(m.GuildID, name)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error loading radio stations!")
+		return
+	}
+	if station == nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("No radio station named **%s**! See `!radio list`", name))
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanAddMusic(userLevel) {
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to add music!")
+		return
+	}
+
+	voiceChannel, err := h.getUserVoiceChannel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "You must be in a voice channel!")
+		return
+	}
+
+	if !h.voiceChannelAllowed(m.GuildID, voiceChannel) {
+		s.ChannelMessageSend(m.ChannelID, "I'm not allowed to join that voice channel!")
+		return
+	}
+
+	if !h.checkSource(s, m, h.guildSources(m.GuildID), music.SourceHTTP) {
+		return
+	}
+
+	msg, _ := s.ChannelMessageSend(m.ChannelID, "Tuning in...")
+
+	// Probe again, since station playlists may point somewhere new
+	ctx, cancel := context.WithTimeout(context.Background(), music.ProbeTimeout)
+	defer cancel()
+
+	stream, err := music.ProbeStream(ctx, h.hosts, station.URL)
+	if err != nil {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+	if stream == nil {
+		s.ChannelMessageEdit(m.ChannelID, msg.ID, fmt.Sprintf("**%s** isn't streaming right now!", station.Name))
+		return
+	}
+
+	h.addTracks(s, m, voiceChannel, msg, []*music.Track{stream.Track(station.Name, m.Author.ID)})
+}
+
+// liveUpdater keeps one live now-playing embed up to date.
+type liveUpdater struct {
+	cancel context.CancelFunc
+}
+
+// sendLiveNowPlaying sends the now-playing embed for a live stream and keeps
+// its StreamTitle up to date for a while, as long as the stream is playing.
+// Each guild has one updater at a time; a newer embed replaces the last.
+func (h *Handler) sendLiveNowPlaying(s *discordgo.Session, m *discordgo.MessageCreate, player *music.Player, track *music.Track, embed *discordgo.MessageEmbed) {
+	title := player.StreamTitle()
+
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:   "Length",
+		Value:  "🔴 LIVE",
+		Inline: true,
+	})
+	titleField := &discordgo.MessageEmbedField{
+		Name:   "On Air",
+		Value:  streamTitleValue(title),
+		Inline: false,
+	}
+	embed.Fields = append(embed.Fields, titleField)
+
+	msg, err := s.ChannelMessageSendEmbed(m.ChannelID, embed)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), streamTitleFollow)
+	updater := &liveUpdater{cancel: cancel}
+
+	h.liveMu.Lock()
+	if previous := h.liveUpdaters[m.GuildID]; previous != nil {
+		previous.cancel()
+	}
+	h.liveUpdaters[m.GuildID] = updater
+	h.liveMu.Unlock()
+
+	go func() {
+		defer func() {
+			cancel()
+			h.liveMu.Lock()
+			if h.liveUpdaters[m.GuildID] == updater {
+				delete(h.liveUpdaters, m.GuildID)
+			}
+			h.liveMu.Unlock()
+		}()
+
+		ticker := time.NewTicker(streamTitleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if player.NowPlaying() != track {
+				return
+			}
+
+			current := player.StreamTitle()
+			if current == title {
+				continue
+			}
+			title = current
+
+			titleField.Value = streamTitleValue(title)
+			if _, err := s.ChannelMessageEditEmbed(m.ChannelID, msg.ID, embed); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func streamTitleValue(title string) string {
+	if title == "" {
+		return "-"
+	}
+	return title
+}
@@ -0,0 +1,201 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"miku_bot/internal/database"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildSetting is a per-guild option exposed through !settings.
+type guildSetting struct {
+	name        string
+	description string
+	get         func(guild *database.Guild) string
+	set         func(h *Handler, s *discordgo.Session, guildID, value string) error
+}
+
+var guildSettings = []guildSetting{
+	{
+		name:        "owntracks",
+		description: "Let users remove, skip or move down tracks they requested",
+		get: func(guild *database.Guild) string {
+			return formatToggle(guild.OwnerPrivileges)
+		},
+		set: func(h *Handler, s *discordgo.Session, guildID, value string) error {
+			enabled, err := parseToggle(value)
+			if err != nil {
+				return err
+			}
+			return h.db.UpdateGuildOwnerPrivileges(guildID, enabled)
+		},
+	},
+	{
+		name:        "alonedj",
+		description: "Treat the only listener in the bot's voice channel as DJ (deafened members and bots don't count, muted members do)",
+		get: func(guild *database.Guild) string {
+			return formatToggle(guild.AloneDJ)
+		},
+		set: func(h *Handler, s *discordgo.Session, guildID, value string) error {
+			enabled, err := parseToggle(value)
+			if err != nil {
+				return err
+			}
+			return h.db.UpdateGuildAloneDJ(guildID, enabled)
+		},
+	},
+	{
+		name:        "logchannel",
+		description: "Channel that receives audit log entries (or off)",
+		get: func(guild *database.Guild) string {
+			if guild.LogChannelID.Valid {
+				return fmt.Sprintf("<#%s>", guild.LogChannelID.String)
+			}
+			return "off"
+		},
+		set: func(h *Handler, s *discordgo.Session, guildID, value string) error {
+			if strings.ToLower(value) == "off" {
+				return h.db.UpdateGuildLogChannel(guildID, "")
+			}
+
+			channelID := strings.Trim(value, "<#>")
+			if _, err := strconv.ParseUint(channelID, 10, 64); err != nil {
+				return fmt.Errorf("expected a #channel or off, got %q", value)
+			}
+
+			// Audit entries must never leave the guild they belong to
+			channel, err := lookupChannel(s, channelID)
+			if err != nil || channel.GuildID != guildID {
+				return fmt.Errorf("channel not found in this server")
+			}
+			if channelKind(channel) != database.ChannelKindText || channel.IsThread() {
+				return fmt.Errorf("the log channel must be a text channel")
+			}
+			return h.db.UpdateGuildLogChannel(guildID, channelID)
+		},
+	},
+}
+
+func findGuildSetting(name string) (*guildSetting, bool) {
+	for i := range guildSettings {
+		if guildSettings[i].name == name {
+			return &guildSettings[i], true
+		}
+	}
+	return nil, false
+}
+
+func parseToggle(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "enable", "enabled":
+		return true, nil
+	case "off", "false", "no", "disable", "disabled":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", value)
+}
+
+func formatToggle(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func (h *Handler) handleSettings(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	guild, err := h.db.GetGuild(m.GuildID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error getting guild settings!")
+		return
+	}
+
+	if len(args) == 0 {
+		embed := &discordgo.MessageEmbed{
+			Title: "Server Settings",
+			Color: 0x9B59B6,
+			Footer: &discordgo.MessageEmbedFooter{
+				Text: "Change with !settings <name> <value>",
+			},
+		}
+
+		for _, setting := range guildSettings {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:   fmt.Sprintf("%s: %s", setting.name, setting.get(guild)),
+				Value:  setting.description,
+				Inline: false,
+			})
+		}
+
+		s.ChannelMessageSendEmbed(m.ChannelID, embed)
+		return
+	}
+
+	setting, ok := findGuildSetting(strings.ToLower(args[0]))
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Unknown setting: %s", args[0]))
+		return
+	}
+
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("**%s** is %s", setting.name, setting.get(guild)))
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanChangeSettings(userLevel) {
+		h.audit(s, m, "settings", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to change settings!")
+		return
+	}
+
+	if err := setting.set(h, s, m.GuildID, strings.Join(args[1:], " ")); err != nil {
+		h.audit(s, m, "settings", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	if err := h.reloadPermission(m.GuildID); err != nil {
+		h.audit(s, m, "settings", args, auditError(err))
+		s.ChannelMessageSend(m.ChannelID, "Error reloading settings!")
+		return
+	}
+
+	guild, err = h.db.GetGuild(m.GuildID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error getting guild settings!")
+		return
+	}
+
+	h.audit(s, m, "settings", args, auditSuccess)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Set **%s** to %s", setting.name, setting.get(guild)))
+}
@@ -0,0 +1,165 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"miku_bot/internal/music"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildSources returns the sources enabled in a guild: those enabled in the
+// config, minus any the guild has switched off. Servers can only narrow the
+// config, never widen it.
+func (h *Handler) guildSources(guildID string) music.SourceSet {
+	names, err := h.db.GetDisabledSources(guildID)
+	if err != nil {
+		log.Printf("Failed to load disabled sources for guild %s: %v", guildID, err)
+		return h.sources
+	}
+
+	disabled := make([]music.Source, 0, len(names))
+	for _, name := range names {
+		disabled = append(disabled, music.Source(name))
+	}
+	return h.sources.Without(disabled...)
+}
+
+// checkSource refuses sources that are disabled in the config or in the
+// guild, telling the user why.
+func (h *Handler) checkSource(s *discordgo.Session, m *discordgo.MessageCreate, sources music.SourceSet, source music.Source) bool {
+	switch {
+	case source == "":
+		s.ChannelMessageSend(m.ChannelID, "I can't play that! Try a link, a file from the library or a search.")
+		return false
+	case !h.sources[source]:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Playing from **%s** is disabled on this bot!", source))
+		return false
+	case !sources[source]:
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Playing from **%s** is disabled on this server!", source))
+		return false
+	}
+	return true
+}
+
+func sourceList(sources music.SourceSet) string {
+	names := sources.Names()
+	if len(names) == 0 {
+		return "None"
+	}
+	return strings.Join(names, ", ")
+}
+
+func (h *Handler) handleSources(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
+	if len(args) == 0 || strings.ToLower(args[0]) == "list" {
+		h.listSources(s, m)
+		return
+	}
+
+	if len(args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!sources enable/disable <source>`")
+		return
+	}
+
+	source, ok := music.ParseSource(args[1])
+	if !ok {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Unknown source: %s! See `!sources`", args[1]))
+		return
+	}
+
+	perm := h.getPermission(m.GuildID)
+	userLevel, err := perm.GetUserLevel(s, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, "Error checking permissions!")
+		return
+	}
+
+	if !perm.CanChangeSettings(userLevel) {
+		h.audit(s, m, "sources", args, auditDenied)
+		s.ChannelMessageSend(m.ChannelID, "You don't have permission to change settings!")
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "enable", "on":
+		if !h.sources[source] {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("**%s** is disabled in the bot config and can't be enabled per server!", source))
+			return
+		}
+
+		removed, err := h.db.EnableSource(m.GuildID, string(source))
+		if err != nil {
+			h.audit(s, m, "sources", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error updating sources!")
+			return
+		}
+		if !removed {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("**%s** is already enabled", source))
+			return
+		}
+
+		h.audit(s, m, "sources", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Enabled **%s** on this server", source))
+	case "disable", "off":
+		if err := h.db.DisableSource(m.GuildID, string(source)); err != nil {
+			h.audit(s, m, "sources", args, auditError(err))
+			s.ChannelMessageSend(m.ChannelID, "Error updating sources!")
+			return
+		}
+
+		h.audit(s, m, "sources", args, auditSuccess)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Disabled **%s** on this server", source))
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Usage: `!sources enable/disable <source>`")
+	}
+}
+
+func (h *Handler) listSources(s *discordgo.Session, m *discordgo.MessageCreate) {
+	sources := h.guildSources(m.GuildID)
+
+	var list strings.Builder
+	for _, source := range music.AllSources {
+		status := "✅ enabled"
+		switch {
+		case !h.sources[source]:
+			status = "⛔ disabled in the bot config"
+		case !sources[source]:
+			status = "❌ disabled on this server"
+		}
+		list.WriteString(fmt.Sprintf("`%s` %s: %s\n", string(source), source, status))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Sources",
+		Description: list.String(),
+		Color:       0x9B59B6,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Change with !sources enable/disable <source>",
+		},
+	}
+
+	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+}
@@ -24,6 +24,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -87,11 +88,176 @@ func (d *Database) Initialize() error {
 		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
 	);
 
+	CREATE TABLE IF NOT EXISTS guild_roles (
+		guild_id TEXT NOT NULL,
+		role_id TEXT NOT NULL,
+		level TEXT NOT NULL,
+		PRIMARY KEY (guild_id, role_id, level),
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS channel_rules (
+		guild_id TEXT NOT NULL,
+		channel_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		mode TEXT NOT NULL,
+		PRIMARY KEY (guild_id, channel_id),
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS bot_bans (
+		guild_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		banned_by TEXT NOT NULL,
+		reason TEXT,
+		expires_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (guild_id, user_id),
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		guild_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		command TEXT NOT NULL,
+		arguments TEXT,
+		outcome TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS library_files (
+		path TEXT PRIMARY KEY,
+		size INTEGER NOT NULL,
+		mod_time INTEGER NOT NULL,
+		title TEXT,
+		artist TEXT,
+		album TEXT,
+		album_art TEXT,
+		duration INTEGER DEFAULT 0,
+		scanned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS radio_stations (
+		guild_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		added_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (guild_id, name),
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS podcasts (
+		guild_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		title TEXT,
+		added_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (guild_id, name),
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS episode_progress (
+		guild_id TEXT NOT NULL,
+		episode_id TEXT NOT NULL,
+		position_ms INTEGER NOT NULL DEFAULT 0,
+		finished BOOLEAN NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (guild_id, episode_id),
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS disabled_sources (
+		guild_id TEXT NOT NULL,
+		source TEXT NOT NULL,
+		PRIMARY KEY (guild_id, source),
+		FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_queue_guild_position ON queue(guild_id, position);
 	CREATE INDEX IF NOT EXISTS idx_history_guild ON playback_history(guild_id);
+	CREATE INDEX IF NOT EXISTS idx_audit_guild_created ON audit_log(guild_id, created_at);
 	`
 
-	_, err := d.DB.Exec(schema)
+	if _, err := d.DB.Exec(schema); err != nil {
+		return err
+	}
+
+	return d.migrate()
+}
+
+// migrate brings databases created by older versions up to the current schema.
+func (d *Database) migrate() error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"guilds", "dj_above_role_id", "TEXT"},
+		{"guilds", "owner_privileges", "INTEGER NOT NULL DEFAULT 1"},
+		{"guilds", "alone_dj", "INTEGER NOT NULL DEFAULT 0"},
+		{"guilds", "log_channel_id", "TEXT"},
+		{"library_files", "scan_version", "INTEGER NOT NULL DEFAULT 0"},
+		{"library_files", "album_artist", "TEXT"},
+		{"library_files", "genre", "TEXT"},
+		{"library_files", "year", "INTEGER NOT NULL DEFAULT 0"},
+		{"library_files", "track_number", "INTEGER NOT NULL DEFAULT 0"},
+		{"library_files", "disc_number", "INTEGER NOT NULL DEFAULT 0"},
+	}
+
+	for _, c := range columns {
+		if err := d.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	// Older versions stored a single DJ and Mod role on the guild row.
+	// Move them into guild_roles and clear the legacy columns so removed
+	// roles don't come back on the next start.
+	legacy := `
+	INSERT OR IGNORE INTO guild_roles (guild_id, role_id, level)
+		SELECT id, dj_role_id, 'dj' FROM guilds WHERE dj_role_id IS NOT NULL AND dj_role_id != '';
+	INSERT OR IGNORE INTO guild_roles (guild_id, role_id, level)
+		SELECT id, mod_role_id, 'mod' FROM guilds WHERE mod_role_id IS NOT NULL AND mod_role_id != '';
+	UPDATE guilds SET dj_role_id = NULL, mod_role_id = NULL WHERE dj_role_id IS NOT NULL OR mod_role_id IS NOT NULL;
+	`
+
+	_, err := d.DB.Exec(legacy)
+	return err
+}
+
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+	rows, err := d.DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = d.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
@@ -100,28 +266,39 @@ func (d *Database) Close() error {
 }
 
 type Guild struct {
-	ID         string
-	Prefix     string
-	DJRoleID   sql.NullString
-	ModRoleID  sql.NullString
-	Volume     int
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	ID              string
+	Prefix          string
+	DJAboveRoleID   sql.NullString
+	OwnerPrivileges bool
+	AloneDJ         bool
+	LogChannelID    sql.NullString
+	Volume          int
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
 
-func (d *Database) GetGuild(guildID string) (*Guild, error) {
-	query := `SELECT id, prefix, dj_role_id, mod_role_id, volume, created_at, updated_at FROM guilds WHERE id = ?`
+const guildColumns = `id, prefix, dj_above_role_id, owner_privileges, alone_dj, log_channel_id, volume, created_at, updated_at`
 
+func scanGuild(row *sql.Row) (*Guild, error) {
 	var guild Guild
-	err := d.DB.QueryRow(query, guildID).Scan(
+	err := row.Scan(
 		&guild.ID,
 		&guild.Prefix,
-		&guild.DJRoleID,
-		&guild.ModRoleID,
+		&guild.DJAboveRoleID,
+		&guild.OwnerPrivileges,
+		&guild.AloneDJ,
+		&guild.LogChannelID,
 		&guild.Volume,
 		&guild.CreatedAt,
 		&guild.UpdatedAt,
 	)
+	return &guild, err
+}
+
+func (d *Database) GetGuild(guildID string) (*Guild, error) {
+	query := `SELECT ` + guildColumns + ` FROM guilds WHERE id = ?`
+
+	guild, err := scanGuild(d.DB.QueryRow(query, guildID))
 
 	if err == sql.ErrNoRows {
 		return d.CreateGuild(guildID)
@@ -131,38 +308,382 @@ func (d *Database) GetGuild(guildID string) (*Guild, error) {
 		return nil, err
 	}
 
-	return &guild, nil
+	return guild, nil
 }
 
 func (d *Database) CreateGuild(guildID string) (*Guild, error) {
-	query := `INSERT INTO guilds (id) VALUES (?) RETURNING id, prefix, dj_role_id, mod_role_id, volume, created_at, updated_at`
+	query := `INSERT INTO guilds (id) VALUES (?) RETURNING ` + guildColumns
 
-	var guild Guild
-	err := d.DB.QueryRow(query, guildID).Scan(
-		&guild.ID,
-		&guild.Prefix,
-		&guild.DJRoleID,
-		&guild.ModRoleID,
-		&guild.Volume,
-		&guild.CreatedAt,
-		&guild.UpdatedAt,
-	)
+	return scanGuild(d.DB.QueryRow(query, guildID))
+}
 
-	return &guild, err
+func (d *Database) UpdateGuildDJAboveRole(guildID, roleID string) error {
+	query := `UPDATE guilds SET dj_above_role_id = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := d.DB.Exec(query, roleID, guildID)
+	return err
+}
+
+func (d *Database) UpdateGuildOwnerPrivileges(guildID string, enabled bool) error {
+	query := `UPDATE guilds SET owner_privileges = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := d.DB.Exec(query, enabled, guildID)
+	return err
+}
+
+func (d *Database) UpdateGuildAloneDJ(guildID string, enabled bool) error {
+	query := `UPDATE guilds SET alone_dj = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := d.DB.Exec(query, enabled, guildID)
+	return err
+}
+
+func (d *Database) UpdateGuildLogChannel(guildID, channelID string) error {
+	query := `UPDATE guilds SET log_channel_id = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := d.DB.Exec(query, channelID, guildID)
+	return err
+}
+
+// Role levels stored in guild_roles.
+const (
+	RoleLevelDJ  = "dj"
+	RoleLevelMod = "mod"
+)
+
+type GuildRole struct {
+	GuildID string
+	RoleID  string
+	Level   string
+}
+
+func (d *Database) GetGuildRoles(guildID string) ([]*GuildRole, error) {
+	query := `SELECT guild_id, role_id, level FROM guild_roles WHERE guild_id = ? ORDER BY level, role_id`
+
+	rows, err := d.DB.Query(query, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*GuildRole
+	for rows.Next() {
+		var role GuildRole
+		if err := rows.Scan(&role.GuildID, &role.RoleID, &role.Level); err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+
+	return roles, rows.Err()
 }
 
-func (d *Database) UpdateGuildRoles(guildID, djRoleID, modRoleID string) error {
-	query := `UPDATE guilds SET dj_role_id = ?, mod_role_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
-	_, err := d.DB.Exec(query, djRoleID, modRoleID, guildID)
+func (d *Database) AddGuildRole(guildID, roleID, level string) error {
+	query := `INSERT OR IGNORE INTO guild_roles (guild_id, role_id, level) VALUES (?, ?, ?)`
+	_, err := d.DB.Exec(query, guildID, roleID, level)
 	return err
 }
 
+func (d *Database) RemoveGuildRole(guildID, roleID, level string) (bool, error) {
+	query := `DELETE FROM guild_roles WHERE guild_id = ? AND role_id = ? AND level = ?`
+	result, err := d.DB.Exec(query, guildID, roleID, level)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
 func (d *Database) UpdateGuildVolume(guildID string, volume int) error {
 	query := `UPDATE guilds SET volume = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
 	_, err := d.DB.Exec(query, volume, guildID)
 	return err
 }
 
+// Channel kinds and modes stored in channel_rules.
+const (
+	ChannelKindText  = "text"
+	ChannelKindVoice = "voice"
+
+	ChannelModeAllow = "allow"
+	ChannelModeDeny  = "deny"
+)
+
+type ChannelRule struct {
+	GuildID   string
+	ChannelID string
+	Kind      string
+	Mode      string
+}
+
+func (d *Database) GetChannelRules(guildID string) ([]*ChannelRule, error) {
+	query := `SELECT guild_id, channel_id, kind, mode FROM channel_rules WHERE guild_id = ? ORDER BY kind, mode`
+
+	rows, err := d.DB.Query(query, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*ChannelRule
+	for rows.Next() {
+		var rule ChannelRule
+		if err := rows.Scan(&rule.GuildID, &rule.ChannelID, &rule.Kind, &rule.Mode); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (d *Database) SetChannelRule(rule *ChannelRule) error {
+	query := `INSERT OR REPLACE INTO channel_rules (guild_id, channel_id, kind, mode) VALUES (?, ?, ?, ?)`
+	_, err := d.DB.Exec(query, rule.GuildID, rule.ChannelID, rule.Kind, rule.Mode)
+	return err
+}
+
+func (d *Database) RemoveChannelRule(guildID, channelID string) (bool, error) {
+	result, err := d.DB.Exec(`DELETE FROM channel_rules WHERE guild_id = ? AND channel_id = ?`, guildID, channelID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (d *Database) ClearChannelRules(guildID string) error {
+	_, err := d.DB.Exec(`DELETE FROM channel_rules WHERE guild_id = ?`, guildID)
+	return err
+}
+
+// GetDisabledSources returns the sources a guild has switched off.
+func (d *Database) GetDisabledSources(guildID string) ([]string, error) {
+	rows, err := d.DB.Query(`SELECT source FROM disabled_sources WHERE guild_id = ?`, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []string
+	for rows.Next() {
+		var source string
+		if err := rows.Scan(&source); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, rows.Err()
+}
+
+func (d *Database) DisableSource(guildID, source string) error {
+	_, err := d.DB.Exec(`INSERT OR IGNORE INTO disabled_sources (guild_id, source) VALUES (?, ?)`, guildID, source)
+	return err
+}
+
+// EnableSource removes a guild's override for source, reporting whether
+// it had one.
+func (d *Database) EnableSource(guildID, source string) (bool, error) {
+	result, err := d.DB.Exec(`DELETE FROM disabled_sources WHERE guild_id = ? AND source = ?`, guildID, source)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+type BotBan struct {
+	GuildID   string
+	UserID    string
+	BannedBy  string
+	Reason    string
+	ExpiresAt sql.NullTime
+	CreatedAt time.Time
+}
+
+func (d *Database) AddBotBan(ban *BotBan) error {
+	query := `INSERT OR REPLACE INTO bot_bans (guild_id, user_id, banned_by, reason, expires_at) VALUES (?, ?, ?, ?, ?)`
+	_, err := d.DB.Exec(query, ban.GuildID, ban.UserID, ban.BannedBy, ban.Reason, ban.ExpiresAt)
+	return err
+}
+
+func (d *Database) RemoveBotBan(guildID, userID string) (bool, error) {
+	result, err := d.DB.Exec(`DELETE FROM bot_bans WHERE guild_id = ? AND user_id = ?`, guildID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (d *Database) GetActiveBotBans(guildID string) ([]*BotBan, error) {
+	query := `SELECT guild_id, user_id, banned_by, reason, expires_at, created_at FROM bot_bans
+		WHERE guild_id = ? AND (expires_at IS NULL OR expires_at > ?) ORDER BY created_at DESC`
+
+	rows, err := d.DB.Query(query, guildID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*BotBan
+	for rows.Next() {
+		var ban BotBan
+		var reason sql.NullString
+		if err := rows.Scan(&ban.GuildID, &ban.UserID, &ban.BannedBy, &reason, &ban.ExpiresAt, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		ban.Reason = reason.String
+		bans = append(bans, &ban)
+	}
+
+	return bans, rows.Err()
+}
+
+type AuditEntry struct {
+	ID        int
+	GuildID   string
+	UserID    string
+	Command   string
+	Arguments string
+	Outcome   string
+	CreatedAt time.Time
+}
+
+func (d *Database) AddAuditEntry(entry *AuditEntry) error {
+	query := `INSERT INTO audit_log (guild_id, user_id, command, arguments, outcome) VALUES (?, ?, ?, ?, ?)`
+	_, err := d.DB.Exec(query, entry.GuildID, entry.UserID, entry.Command, entry.Arguments, entry.Outcome)
+	return err
+}
+
+// GetAuditLog returns the most recent entries for a guild, newest first.
+// Empty userID or command match everything.
+func (d *Database) GetAuditLog(guildID, userID, command string, limit int) ([]*AuditEntry, error) {
+	query := `SELECT id, guild_id, user_id, command, arguments, outcome, created_at FROM audit_log
+		WHERE guild_id = ? AND (? = '' OR user_id = ?) AND (? = '' OR command = ?)
+		ORDER BY id DESC LIMIT ?`
+
+	rows, err := d.DB.Query(query, guildID, userID, userID, command, command, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var arguments sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.GuildID, &entry.UserID, &entry.Command, &arguments, &entry.Outcome, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Arguments = arguments.String
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// LibraryFile is the indexed state of one file in the local music library.
+// Size and ModTime let rescans skip files that haven't changed.
+type LibraryFile struct {
+	Path        string
+	Size        int64
+	ModTime     int64 // Unix nanoseconds
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Genre       string
+	Year        int
+	TrackNumber int
+	DiscNumber  int
+	AlbumArt    string
+	Duration    int
+	// ScanVersion records which version of the scanner produced the entry,
+	// so entries can be refreshed when the scanner learns to extract more.
+	ScanVersion int
+}
+
+const libraryFileColumns = `path, size, mod_time, title, artist, album, album_artist, genre, year,
+	track_number, disc_number, album_art, duration, scan_version`
+
+func (d *Database) GetLibraryFiles() ([]*LibraryFile, error) {
+	rows, err := d.DB.Query(`SELECT ` + libraryFileColumns + ` FROM library_files`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*LibraryFile
+	for rows.Next() {
+		var file LibraryFile
+		var title, artist, album, albumArtist, genre, albumArt sql.NullString
+		err := rows.Scan(&file.Path, &file.Size, &file.ModTime, &title, &artist, &album, &albumArtist, &genre, &file.Year,
+			&file.TrackNumber, &file.DiscNumber, &albumArt, &file.Duration, &file.ScanVersion)
+		if err != nil {
+			return nil, err
+		}
+		file.Title = title.String
+		file.Artist = artist.String
+		file.Album = album.String
+		file.AlbumArtist = albumArtist.String
+		file.Genre = genre.String
+		file.AlbumArt = albumArt.String
+		files = append(files, &file)
+	}
+
+	return files, rows.Err()
+}
+
+// SaveLibraryFiles inserts or updates index entries in a single transaction.
+func (d *Database) SaveLibraryFiles(files []*LibraryFile) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO library_files (` + libraryFileColumns + `, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, file := range files {
+		_, err := stmt.Exec(file.Path, file.Size, file.ModTime, file.Title, file.Artist, file.Album, file.AlbumArtist, file.Genre, file.Year,
+			file.TrackNumber, file.DiscNumber, file.AlbumArt, file.Duration, file.ScanVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) DeleteLibraryFiles(paths []string) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM library_files WHERE path = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, path := range paths {
+		if _, err := stmt.Exec(path); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 type QueueItem struct {
 	ID        int
 	GuildID   string
@@ -187,6 +708,36 @@ func (d *Database) AddToQueue(item *QueueItem) error {
 	return err
 }
 
+// AddToQueueBatch appends items to the end of their guild's queue in one
+// transaction.
+func (d *Database) AddToQueueBatch(items []*QueueItem) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO queue (guild_id, channel_id, user_id, title, url, duration, thumbnail, position)
+		VALUES (?, ?, ?, ?, ?, ?, ?,
+			COALESCE((SELECT MAX(position) + 1 FROM queue WHERE guild_id = ?), 0)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, item := range items {
+		_, err := stmt.Exec(item.GuildID, item.ChannelID, item.UserID, item.Title, item.URL, item.Duration, item.Thumbnail, item.GuildID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (d *Database) GetQueue(guildID string) ([]*QueueItem, error) {
 	query := `SELECT id, guild_id, channel_id, user_id, title, url, duration, thumbnail, position, added_at FROM queue WHERE guild_id = ? ORDER BY position ASC`
 
@@ -259,8 +810,243 @@ func (d *Database) MoveToTop(guildID string, position int) error {
 	return tx.Commit()
 }
 
+// RemoveUserTracks deletes every queued track requested by a user and
+// renumbers the remaining positions.
+func (d *Database) RemoveUserTracks(guildID, userID string) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM queue WHERE guild_id = ? AND user_id = ?`, guildID, userID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id FROM queue WHERE guild_id = ? ORDER BY position ASC`, guildID)
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for position, id := range ids {
+		if _, err := tx.Exec(`UPDATE queue SET position = ? WHERE id = ?`, position, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) MoveTrack(guildID string, from, to int) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE queue SET position = -1 WHERE guild_id = ? AND position = ?`, guildID, from)
+	if err != nil {
+		return err
+	}
+
+	if from < to {
+		_, err = tx.Exec(`UPDATE queue SET position = position - 1 WHERE guild_id = ? AND position > ? AND position <= ?`, guildID, from, to)
+	} else {
+		_, err = tx.Exec(`UPDATE queue SET position = position + 1 WHERE guild_id = ? AND position >= ? AND position < ?`, guildID, to, from)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE queue SET position = ? WHERE guild_id = ? AND position = -1`, to, guildID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (d *Database) AddToHistory(guildID, userID, title, url string) error {
 	query := `INSERT INTO playback_history (guild_id, user_id, title, url) VALUES (?, ?, ?, ?)`
 	_, err := d.DB.Exec(query, guildID, userID, title, url)
 	return err
 }
+
+// RadioStation is a live stream saved to a guild's favorites. Names are
+// matched case-insensitively.
+type RadioStation struct {
+	GuildID   string
+	Name      string
+	URL       string
+	AddedBy   string
+	CreatedAt time.Time
+}
+
+func (d *Database) AddRadioStation(station *RadioStation) error {
+	query := `INSERT OR REPLACE INTO radio_stations (guild_id, name, url, added_by) VALUES (?, ?, ?, ?)`
+	_, err := d.DB.Exec(query, station.GuildID, strings.ToLower(station.Name), station.URL, station.AddedBy)
+	return err
+}
+
+func (d *Database) RemoveRadioStation(guildID, name string) (bool, error) {
+	result, err := d.DB.Exec(`DELETE FROM radio_stations WHERE guild_id = ? AND name = ?`, guildID, strings.ToLower(name))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetRadioStation returns the named station, or nil if there is none.
+func (d *Database) GetRadioStation(guildID, name string) (*RadioStation, error) {
+	query := `SELECT guild_id, name, url, added_by, created_at FROM radio_stations WHERE guild_id = ? AND name = ?`
+
+	var station RadioStation
+	err := d.DB.QueryRow(query, guildID, strings.ToLower(name)).Scan(
+		&station.GuildID, &station.Name, &station.URL, &station.AddedBy, &station.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &station, nil
+}
+
+func (d *Database) GetRadioStations(guildID string) ([]*RadioStation, error) {
+	query := `SELECT guild_id, name, url, added_by, created_at FROM radio_stations WHERE guild_id = ? ORDER BY name`
+
+	rows, err := d.DB.Query(query, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stations []*RadioStation
+	for rows.Next() {
+		var station RadioStation
+		if err := rows.Scan(&station.GuildID, &station.Name, &station.URL, &station.AddedBy, &station.CreatedAt); err != nil {
+			return nil, err
+		}
+		stations = append(stations, &station)
+	}
+
+	return stations, rows.Err()
+}
+
+// Podcast is a feed a guild has subscribed to. Names are matched
+// case-insensitively.
+type Podcast struct {
+	GuildID   string
+	Name      string
+	URL       string
+	Title     string
+	AddedBy   string
+	CreatedAt time.Time
+}
+
+func (d *Database) AddPodcast(podcast *Podcast) error {
+	query := `INSERT OR REPLACE INTO podcasts (guild_id, name, url, title, added_by) VALUES (?, ?, ?, ?, ?)`
+	_, err := d.DB.Exec(query, podcast.GuildID, strings.ToLower(podcast.Name), podcast.URL, podcast.Title, podcast.AddedBy)
+	return err
+}
+
+func (d *Database) RemovePodcast(guildID, name string) (bool, error) {
+	result, err := d.DB.Exec(`DELETE FROM podcasts WHERE guild_id = ? AND name = ?`, guildID, strings.ToLower(name))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetPodcast returns the named subscription, or nil if there is none.
+func (d *Database) GetPodcast(guildID, name string) (*Podcast, error) {
+	query := `SELECT guild_id, name, url, title, added_by, created_at FROM podcasts WHERE guild_id = ? AND name = ?`
+
+	var podcast Podcast
+	var title sql.NullString
+	err := d.DB.QueryRow(query, guildID, strings.ToLower(name)).Scan(
+		&podcast.GuildID, &podcast.Name, &podcast.URL, &title, &podcast.AddedBy, &podcast.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	podcast.Title = title.String
+	return &podcast, nil
+}
+
+func (d *Database) GetPodcasts(guildID string) ([]*Podcast, error) {
+	query := `SELECT guild_id, name, url, title, added_by, created_at FROM podcasts WHERE guild_id = ? ORDER BY name`
+
+	rows, err := d.DB.Query(query, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var podcasts []*Podcast
+	for rows.Next() {
+		var podcast Podcast
+		var title sql.NullString
+		if err := rows.Scan(&podcast.GuildID, &podcast.Name, &podcast.URL, &title, &podcast.AddedBy, &podcast.CreatedAt); err != nil {
+			return nil, err
+		}
+		podcast.Title = title.String
+		podcasts = append(podcasts, &podcast)
+	}
+
+	return podcasts, rows.Err()
+}
+
+// EpisodeProgress is how far a guild got through a podcast episode.
+type EpisodeProgress struct {
+	GuildID   string
+	EpisodeID string
+	Position  time.Duration
+	Finished  bool
+}
+
+func (d *Database) SaveEpisodeProgress(progress *EpisodeProgress) error {
+	query := `INSERT OR REPLACE INTO episode_progress (guild_id, episode_id, position_ms, finished, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	_, err := d.DB.Exec(query, progress.GuildID, progress.EpisodeID, progress.Position.Milliseconds(), progress.Finished)
+	return err
+}
+
+// GetEpisodeProgress returns the guild's progress through an episode, or
+// nil if it hasn't started it.
+func (d *Database) GetEpisodeProgress(guildID, episodeID string) (*EpisodeProgress, error) {
+	query := `SELECT position_ms, finished FROM episode_progress WHERE guild_id = ? AND episode_id = ?`
+
+	progress := &EpisodeProgress{GuildID: guildID, EpisodeID: episodeID}
+	var positionMS int64
+	err := d.DB.QueryRow(query, guildID, episodeID).Scan(&positionMS, &progress.Finished)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	progress.Position = time.Duration(positionMS) * time.Millisecond
+	return progress, nil
+}
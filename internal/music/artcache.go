@@ -0,0 +1,401 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Register decoders for image.Decode
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"miku_bot/internal/database"
+)
+
+// Defaults for ArtCache limits.
+const (
+	DefaultArtMaxDimension = 512
+	DefaultArtMaxSizeMB    = 256
+
+	// maxArtInput skips absurdly large embedded images instead of decoding
+	// them.
+	maxArtInput = 20 << 20
+
+	// artGracePeriod keeps freshly stored art from being collected before
+	// the scan that stored it has saved its index records.
+	artGracePeriod = time.Hour
+
+	// artEvictRetry is how long Store waits before trying to evict again
+	// after finding nothing it could evict.
+	artEvictRetry = time.Minute
+)
+
+// ArtCache stores album art on disk, named by a hash of the original image
+// so every track sharing a cover shares one file. Images are downscaled
+// when stored so they are small enough to attach to embeds.
+type ArtCache struct {
+	dir          string
+	db           *database.Database
+	maxBytes     int64 // 0 means unlimited
+	maxDimension int
+
+	mu        sync.Mutex
+	size      int64     // Bytes currently in the cache
+	nextEvict time.Time // Don't try evicting before this
+	dropped   int       // Images dropped since the cache was last logged as full
+}
+
+// NewArtCache opens (creating if needed) the cache directory. maxSizeMB
+// caps the total cache size, and maxDimension the width and height of
+// stored images; zero selects the defaults.
+func NewArtCache(dir string, db *database.Database, maxSizeMB, maxDimension int) (*ArtCache, error) {
+	if maxSizeMB == 0 {
+		maxSizeMB = DefaultArtMaxSizeMB
+	}
+	if maxDimension <= 0 {
+		maxDimension = DefaultArtMaxDimension
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create album art cache directory: %w", err)
+	}
+
+	cache := &ArtCache{
+		dir:          dir,
+		db:           db,
+		maxBytes:     int64(max(maxSizeMB, 0)) << 20,
+		maxDimension: maxDimension,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read album art cache directory: %w", err)
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			cache.size += info.Size()
+		}
+	}
+
+	return cache, nil
+}
+
+// Store saves an image and returns its path in the cache. Images already
+// in the cache are reused. When the cache is full the least recently used
+// art no track refers to is evicted to make room; it returns "" if there
+// is none to evict or the image can't be decoded.
+func (c *ArtCache) Store(data []byte) string {
+	if len(data) == 0 || len(data) > maxArtInput {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:16])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ext := range []string{".jpg", ".png"} {
+		path := filepath.Join(c.dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			// Refresh the modification time so garbage collection sees
+			// the file as in use
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			return path
+		}
+	}
+
+	encoded, ext, err := c.resize(data)
+	if err != nil {
+		return ""
+	}
+
+	if c.maxBytes > 0 && c.size+int64(len(encoded)) > c.maxBytes && !c.evict(int64(len(encoded))) {
+		c.dropped++
+		return ""
+	}
+
+	path := filepath.Join(c.dir, name+ext)
+	if err := os.WriteFile(path, encoded, 0644); err != nil {
+		log.Printf("Failed to cache album art: %v", err)
+		return ""
+	}
+	c.size += int64(len(encoded))
+
+	return path
+}
+
+// evict deletes unreferenced art, least recently used first, until need
+// more bytes fit in the cache. Art stored within artGracePeriod is kept,
+// as in Collect. Callers must hold c.mu.
+func (c *ArtCache) evict(need int64) bool {
+	now := time.Now()
+	if now.Before(c.nextEvict) {
+		return false
+	}
+
+	referenced, err := c.referenced()
+	if err != nil {
+		log.Printf("Failed to evict album art: %v", err)
+		c.nextEvict = now.Add(artEvictRetry)
+		return false
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("Failed to evict album art: %v", err)
+		c.nextEvict = now.Add(artEvictRetry)
+		return false
+	}
+
+	cutoff := now.Add(-artGracePeriod)
+	var candidates []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || referenced[entry.Name()] || info.ModTime().After(cutoff) {
+			continue
+		}
+		candidates = append(candidates, info)
+	}
+	slices.SortFunc(candidates, func(a, b os.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+
+	for _, info := range candidates {
+		if c.size+need <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err != nil {
+			continue
+		}
+		c.size -= info.Size()
+	}
+
+	if c.size+need > c.maxBytes {
+		log.Printf("Album art cache is full (%d MB) and all of it is in use, not caching new art (%d images dropped so far)",
+			c.maxBytes>>20, c.dropped+1)
+		c.nextEvict = now.Add(artEvictRetry)
+		return false
+	}
+	return true
+}
+
+// referenced returns the names of the cached files index records use.
+func (c *ArtCache) referenced() (map[string]bool, error) {
+	records, err := c.db.GetLibraryFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, record := range records {
+		if record.AlbumArt != "" {
+			referenced[filepath.Base(record.AlbumArt)] = true
+		}
+	}
+	return referenced, nil
+}
+
+// resize downscales an image to fit maxDimension. Images that already fit
+// are kept as they are if Discord can show them.
+func (c *ArtCache) resize(data []byte) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	fits := config.Width <= c.maxDimension && config.Height <= c.maxDimension
+	switch {
+	case fits && format == "jpeg":
+		return data, ".jpg", nil
+	case fits && format == "png":
+		return data, ".png", nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if !fits {
+		img = downscale(img, c.maxDimension)
+	}
+
+	var buf bytes.Buffer
+	if format == "png" || format == "gif" {
+		err = png.Encode(&buf, img)
+		return buf.Bytes(), ".png", err
+	}
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	return buf.Bytes(), ".jpg", err
+}
+
+// downscale shrinks img so neither side exceeds maxDimension, averaging
+// the source pixels covered by each destination pixel.
+func downscale(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := maxDimension, maxDimension
+	if srcW > srcH {
+		dstH = max(srcH*maxDimension/srcW, 1)
+	} else {
+		dstW = max(srcW*maxDimension/srcH, 1)
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// Collect deletes cached art that no index record refers to anymore. Files
+// stored within the last artGracePeriod are kept, since a running scan may
+// not have saved the records that use them yet.
+func (c *ArtCache) Collect() (removed int, freed int64, err error) {
+	referenced, err := c.referenced()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cutoff := time.Now().Add(-artGracePeriod)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || referenced[entry.Name()] || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, entry.Name())); err != nil {
+			continue
+		}
+		removed++
+		freed += info.Size()
+		c.size -= info.Size()
+	}
+
+	return removed, freed, nil
+}
+
+// sidecarNames are the cover images looked for next to audio files without
+// embedded art, in order of preference.
+var sidecarNames = []string{
+	"cover.jpg", "cover.jpeg", "cover.png",
+	"folder.jpg", "folder.jpeg", "folder.png",
+	"front.jpg", "front.png",
+	"album.jpg", "album.png",
+}
+
+// sidecarArt caches the cover image in dir and returns its path in the
+// art cache, or "" if there is none. Results are remembered until
+// resetSidecars is called, so an album's cover is only read once per scan.
+func (l *Library) sidecarArt(dir string) string {
+	l.sidecarMu.Lock()
+	defer l.sidecarMu.Unlock()
+
+	if path, ok := l.sidecars[dir]; ok {
+		return path
+	}
+
+	artPath := ""
+	if entries, err := os.ReadDir(dir); err == nil {
+		names := make(map[string]string, len(entries))
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				names[strings.ToLower(entry.Name())] = entry.Name()
+			}
+		}
+		for _, candidate := range sidecarNames {
+			name, ok := names[candidate]
+			if !ok {
+				continue
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+				artPath = l.art.Store(data)
+			}
+			break
+		}
+	}
+
+	l.sidecars[dir] = artPath
+	return artPath
+}
+
+func isSidecar(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, candidate := range sidecarNames {
+		if name == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Library) resetSidecars() {
+	l.sidecarMu.Lock()
+	l.sidecars = make(map[string]string)
+	l.sidecarMu.Unlock()
+}
@@ -0,0 +1,279 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for Attachments limits.
+const (
+	// DefaultAttachmentMaxSizeMB matches Discord's upload limit for
+	// servers without boosts.
+	DefaultAttachmentMaxSizeMB = 25
+	DefaultAttachmentCacheMB   = 512
+
+	attachmentTimeout = 2 * time.Minute
+
+	// AttachmentProbeTimeout bounds reading the durations of the files
+	// queued by one command.
+	AttachmentProbeTimeout = 30 * time.Second
+)
+
+var (
+	ErrNotAudio           = errors.New("not an audio file")
+	ErrAttachmentTooLarge = errors.New("file is too large")
+)
+
+// attachmentTypes are the non-audio content types ffmpeg can still pull an
+// audio track out of.
+var attachmentTypes = map[string]bool{
+	"application/ogg": true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"video/ogg":       true,
+	"video/quicktime": true,
+}
+
+// Attachments checks audio files uploaded to Discord and, if a cache
+// directory is configured, keeps local copies of them so replays don't
+// download the file again.
+type Attachments struct {
+	maxBytes      int64
+	cacheDir      string // Empty disables the cache
+	cacheMaxBytes int64  // 0 means unlimited
+	client        *http.Client
+
+	mu       sync.Mutex
+	fetching map[string]bool // Cache paths being downloaded
+}
+
+// NewAttachments creates the attachment handler. maxSizeMB caps the size of
+// files that are played, and cacheMaxMB the total size of the cache; zero
+// selects the defaults and -1 makes the cache unlimited. Downloads only go
+// to hosts the host policy allows.
+func NewAttachments(hosts *HostFilter, maxSizeMB int, cacheDir string, cacheMaxMB int) (*Attachments, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = DefaultAttachmentMaxSizeMB
+	}
+	if cacheMaxMB == 0 {
+		cacheMaxMB = DefaultAttachmentCacheMB
+	}
+
+	if cacheDir != "" {
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create attachment cache directory: %w", err)
+		}
+	}
+
+	return &Attachments{
+		maxBytes:      int64(maxSizeMB) << 20,
+		cacheDir:      cacheDir,
+		cacheMaxBytes: int64(max(cacheMaxMB, 0)) << 20,
+		client:        &http.Client{Timeout: attachmentTimeout, Transport: hosts.client.Transport},
+		fetching:      make(map[string]bool),
+	}, nil
+}
+
+// MaxSizeMB returns the largest attachment that is played, in megabytes.
+func (a *Attachments) MaxSizeMB() int {
+	return int(a.maxBytes >> 20)
+}
+
+// Check reports whether an attachment can be played. Discord leaves the
+// content type empty for some uploads, in which case the file extension
+// decides.
+func (a *Attachments) Check(filename, contentType string, size int) error {
+	if int64(size) > a.maxBytes {
+		return fmt.Errorf("%w: %s is %.1f MB, the limit is %d MB", ErrAttachmentTooLarge, filename, float64(size)/(1<<20), a.MaxSizeMB())
+	}
+
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil && (strings.HasPrefix(mediaType, "audio/") || attachmentTypes[mediaType]) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s (%s)", ErrNotAudio, filename, contentType)
+	}
+
+	if ext := strings.ToLower(filepath.Ext(filename)); supportedExtensions[ext] || ext == ".webm" || ext == ".mp4" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotAudio, filename)
+}
+
+// Track returns a track playing an attachment. The file is streamed from
+// url until a cached copy exists; the first time an attachment is queued,
+// the cache is filled in the background. id must be the attachment's ID,
+// which names the cached copy, and size its size in bytes. ctx bounds
+// reading the duration when Discord doesn't report one.
+func (a *Attachments) Track(ctx context.Context, id, filename, url string, size int, seconds float64, requester string) *Track {
+	track := &Track{
+		Title:     strings.TrimSuffix(filename, filepath.Ext(filename)),
+		URL:       url,
+		Duration:  int(seconds + 0.5),
+		Requester: requester,
+		IsDirect:  true,
+	}
+
+	cached := false
+	if a.cacheDir != "" && isSnowflake(id) {
+		track.CachePath = filepath.Join(a.cacheDir, id+strings.ToLower(filepath.Ext(filename)))
+		if _, err := os.Stat(track.CachePath); err == nil {
+			// Refresh the modification time so eviction sees it as in use
+			now := time.Now()
+			os.Chtimes(track.CachePath, now, now)
+			cached = true
+		} else {
+			go a.fetch(url, track.CachePath)
+		}
+	}
+
+	// Discord only reports durations for voice messages. Remote files are
+	// read through the policy client rather than handed to ffprobe, which
+	// would fetch the URL unchecked.
+	if track.Duration == 0 {
+		if cached {
+			track.Duration = probeDuration(ctx, track.CachePath)
+		} else {
+			track.Duration = remoteDuration(ctx, a.client, url, int64(size), filepath.Ext(filename))
+		}
+	}
+
+	return track
+}
+
+// fetch downloads an attachment into the cache. It writes to a temporary
+// file first so the player never picks up a partial copy.
+func (a *Attachments) fetch(url, path string) {
+	a.mu.Lock()
+	if a.fetching[path] {
+		a.mu.Unlock()
+		return
+	}
+	a.fetching[path] = true
+	a.mu.Unlock()
+
+	defer func() {
+		a.mu.Lock()
+		delete(a.fetching, path)
+		a.mu.Unlock()
+	}()
+
+	if err := a.download(url, path); err != nil {
+		log.Printf("Failed to cache attachment %s: %v", filepath.Base(path), err)
+		return
+	}
+	a.evict()
+}
+
+func (a *Attachments) download(url, path string) error {
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(a.cacheDir, ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, a.maxBytes+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n > a.maxBytes {
+		return ErrAttachmentTooLarge
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// evict deletes the least recently used files until the cache fits its
+// size limit.
+func (a *Attachments) evict() {
+	if a.cacheMaxBytes == 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(a.cacheDir)
+	if err != nil {
+		return
+	}
+
+	var files []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, info := range files {
+		if total <= a.cacheMaxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(a.cacheDir, info.Name())); err == nil {
+			total -= info.Size()
+		}
+	}
+}
+
+func isSnowflake(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
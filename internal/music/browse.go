@@ -0,0 +1,340 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"sort"
+	"strings"
+)
+
+// Album groups the tracks of one release. Tracks are in disc and track
+// number order.
+type Album struct {
+	Name   string
+	Artist string // Album artist, the shared track artist, or "Various Artists"
+	Year   int
+	Folder string
+	Tracks []*LocalFile
+}
+
+// ArtistSummary describes one artist in the library.
+type ArtistSummary struct {
+	Name   string
+	Albums int
+	Tracks int
+}
+
+// GenreSummary describes one genre in the library.
+type GenreSummary struct {
+	Name   string
+	Tracks int
+}
+
+// browseIndex groups library files by artist, album, genre and year, and
+// holds their normalized search fields. It is rebuilt lazily after the
+// library changes.
+type browseIndex struct {
+	albums         []*Album
+	artists        map[string]*ArtistSummary
+	albumsByArtist map[string][]*Album
+	genres         map[string]*GenreSummary
+	filesByGenre   map[string][]*LocalFile
+	filesByYear    map[int][]*LocalFile
+	search         []searchEntry
+	searchByFolder map[string][]searchEntry
+}
+
+func normalizeKey(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// albumKey identifies the album a file belongs to. Without an album artist,
+// the folder keeps same-named albums by different artists apart while still
+// grouping compilations whose tracks have different artists.
+func albumKey(file *LocalFile) string {
+	if file.AlbumArtist != "" {
+		return normalizeKey(file.AlbumArtist) + "\x00" + normalizeKey(file.Album)
+	}
+	return normalizeKey(file.Album) + "\x00" + file.Folder
+}
+
+func buildBrowseIndex(files map[string][]*LocalFile) *browseIndex {
+	index := &browseIndex{
+		artists:        make(map[string]*ArtistSummary),
+		albumsByArtist: make(map[string][]*Album),
+		genres:         make(map[string]*GenreSummary),
+		filesByGenre:   make(map[string][]*LocalFile),
+		filesByYear:    make(map[int][]*LocalFile),
+		searchByFolder: make(map[string][]searchEntry),
+	}
+
+	albums := make(map[string]*Album)
+	for folder, folderFiles := range files {
+		for _, file := range folderFiles {
+			entry := newSearchEntry(file)
+			index.search = append(index.search, entry)
+			index.searchByFolder[folder] = append(index.searchByFolder[folder], entry)
+
+			if file.Album != "" {
+				key := albumKey(file)
+				album, ok := albums[key]
+				if !ok {
+					album = &Album{Name: file.Album, Folder: file.Folder}
+					albums[key] = album
+				}
+				album.Tracks = append(album.Tracks, file)
+				if album.Year == 0 {
+					album.Year = file.Year
+				}
+			}
+
+			for _, name := range []string{file.Artist, file.AlbumArtist} {
+				if name == "" {
+					continue
+				}
+				key := normalizeKey(name)
+				if _, ok := index.artists[key]; !ok {
+					index.artists[key] = &ArtistSummary{Name: name}
+				}
+			}
+			if file.Artist != "" {
+				index.artists[normalizeKey(file.Artist)].Tracks++
+			}
+
+			if file.Genre != "" {
+				key := normalizeKey(file.Genre)
+				if _, ok := index.genres[key]; !ok {
+					index.genres[key] = &GenreSummary{Name: file.Genre}
+				}
+				index.genres[key].Tracks++
+				index.filesByGenre[key] = append(index.filesByGenre[key], file)
+			}
+
+			if file.Year > 0 {
+				index.filesByYear[file.Year] = append(index.filesByYear[file.Year], file)
+			}
+		}
+	}
+
+	for _, album := range albums {
+		SortByTrackNumber(album.Tracks)
+		album.Artist = albumArtist(album.Tracks)
+
+		// An album is listed under its album artist and every track artist,
+		// so compilations show up for each contributor
+		seen := make(map[string]bool)
+		for _, file := range album.Tracks {
+			for _, name := range []string{file.AlbumArtist, file.Artist} {
+				key := normalizeKey(name)
+				if name == "" || seen[key] {
+					continue
+				}
+				seen[key] = true
+				index.albumsByArtist[key] = append(index.albumsByArtist[key], album)
+				index.artists[key].Albums++
+			}
+		}
+
+		index.albums = append(index.albums, album)
+	}
+
+	sortAlbums(index.albums)
+	for _, artistAlbums := range index.albumsByArtist {
+		sortAlbums(artistAlbums)
+	}
+	for _, genreFiles := range index.filesByGenre {
+		sortByArtistAndAlbum(genreFiles)
+	}
+	for _, yearFiles := range index.filesByYear {
+		sortByArtistAndAlbum(yearFiles)
+	}
+
+	return index
+}
+
+func albumArtist(tracks []*LocalFile) string {
+	artist := ""
+	for _, file := range tracks {
+		if file.AlbumArtist != "" {
+			return file.AlbumArtist
+		}
+		if file.Artist == "" {
+			continue
+		}
+		if artist != "" && normalizeKey(artist) != normalizeKey(file.Artist) {
+			return "Various Artists"
+		}
+		artist = file.Artist
+	}
+	return artist
+}
+
+// SortByTrackNumber orders files by disc number, track number and name.
+func SortByTrackNumber(files []*LocalFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return a.Name < b.Name
+	})
+}
+
+func sortAlbums(albums []*Album) {
+	sort.Slice(albums, func(i, j int) bool {
+		a, b := albums[i], albums[j]
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		return normalizeKey(a.Name) < normalizeKey(b.Name)
+	})
+}
+
+func sortByArtistAndAlbum(files []*LocalFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if ak, bk := normalizeKey(a.Artist), normalizeKey(b.Artist); ak != bk {
+			return ak < bk
+		}
+		if ak, bk := normalizeKey(a.Album), normalizeKey(b.Album); ak != bk {
+			return ak < bk
+		}
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		return a.TrackNumber < b.TrackNumber
+	})
+}
+
+// browse returns the current browse index, rebuilding it if the library
+// changed since it was last built.
+func (l *Library) browse() *browseIndex {
+	l.mu.RLock()
+	index := l.index
+	l.mu.RUnlock()
+	if index != nil {
+		return index
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.index == nil {
+		l.index = buildBrowseIndex(l.files)
+	}
+	return l.index
+}
+
+// Artists lists every track and album artist, sorted by name.
+func (l *Library) Artists() []*ArtistSummary {
+	index := l.browse()
+
+	artists := make([]*ArtistSummary, 0, len(index.artists))
+	for _, artist := range index.artists {
+		artists = append(artists, artist)
+	}
+	sort.Slice(artists, func(i, j int) bool {
+		return normalizeKey(artists[i].Name) < normalizeKey(artists[j].Name)
+	})
+	return artists
+}
+
+// AlbumsByArtist returns the albums an artist appears on. An exact
+// (case-insensitive) name match wins; otherwise partial matches are used.
+func (l *Library) AlbumsByArtist(artist string) []*Album {
+	index := l.browse()
+	key := normalizeKey(artist)
+
+	if albums, ok := index.albumsByArtist[key]; ok {
+		return albums
+	}
+
+	var albums []*Album
+	seen := make(map[*Album]bool)
+	for name, artistAlbums := range index.albumsByArtist {
+		if !strings.Contains(name, key) {
+			continue
+		}
+		for _, album := range artistAlbums {
+			if !seen[album] {
+				seen[album] = true
+				albums = append(albums, album)
+			}
+		}
+	}
+	sortAlbums(albums)
+	return albums
+}
+
+// FindAlbums returns albums with the given name, falling back to albums
+// whose name contains it.
+func (l *Library) FindAlbums(name string) []*Album {
+	index := l.browse()
+	key := normalizeKey(name)
+
+	var exact, partial []*Album
+	for _, album := range index.albums {
+		albumName := normalizeKey(album.Name)
+		switch {
+		case albumName == key:
+			exact = append(exact, album)
+		case strings.Contains(albumName, key):
+			partial = append(partial, album)
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+// Genres lists every genre, sorted by name.
+func (l *Library) Genres() []*GenreSummary {
+	index := l.browse()
+
+	genres := make([]*GenreSummary, 0, len(index.genres))
+	for _, genre := range index.genres {
+		genres = append(genres, genre)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		return normalizeKey(genres[i].Name) < normalizeKey(genres[j].Name)
+	})
+	return genres
+}
+
+// FilesByGenre returns the tracks tagged with a genre, ordered by artist
+// and album.
+func (l *Library) FilesByGenre(genre string) []*LocalFile {
+	files := l.browse().filesByGenre[normalizeKey(genre)]
+	return append([]*LocalFile(nil), files...)
+}
+
+// FilesByYear returns the tracks released in a year, ordered by artist and
+// album.
+func (l *Library) FilesByYear(year int) []*LocalFile {
+	files := l.browse().filesByYear[year]
+	return append([]*LocalFile(nil), files...)
+}
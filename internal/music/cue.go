@@ -0,0 +1,305 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// cueSheet is a parsed .cue file describing the tracks inside one or more
+// audio files.
+type cueSheet struct {
+	Title     string
+	Performer string
+	Genre     string
+	Date      string
+	Disc      int
+	Files     []*cueFile
+}
+
+// cueFile is one FILE section of a CUE sheet.
+type cueFile struct {
+	Path   string // Absolute path of the audio file
+	Sheet  *cueSheet
+	Tracks []cueTrack
+}
+
+type cueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	Start     time.Duration // INDEX 01 position in the file
+}
+
+// cueFramesPerSecond is the CD frame rate used by CUE timestamps (mm:ss:ff).
+const cueFramesPerSecond = 75
+
+// parseCueSheet reads a CUE sheet. FILE entries are resolved against the
+// sheet's directory.
+func parseCueSheet(path string) (*cueSheet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Most rippers write UTF-8, older ones the system code page; treat
+	// anything that isn't valid UTF-8 as Latin-1
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := string(data)
+	if !utf8.Valid(data) {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+
+	sheet := &cueSheet{}
+	dir := filepath.Dir(path)
+	var file *cueFile
+	var track *cueTrack
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		command, rest, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		rest = strings.TrimSpace(rest)
+
+		switch strings.ToUpper(command) {
+		case "FILE":
+			name := cueFileName(rest)
+			if filepath.Separator == '/' {
+				name = strings.ReplaceAll(name, `\`, "/")
+			}
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(dir, name)
+			}
+			file = &cueFile{Path: filepath.Clean(name), Sheet: sheet}
+			sheet.Files = append(sheet.Files, file)
+			track = nil
+		case "TRACK":
+			if file == nil {
+				return nil, fmt.Errorf("TRACK before FILE")
+			}
+			fields := strings.Fields(rest)
+			if len(fields) == 0 {
+				continue
+			}
+			number, _ := strconv.Atoi(fields[0])
+			file.Tracks = append(file.Tracks, cueTrack{Number: number, Start: -1})
+			track = &file.Tracks[len(file.Tracks)-1]
+		case "INDEX":
+			fields := strings.Fields(rest)
+			if track == nil || len(fields) != 2 || fields[0] != "01" {
+				continue
+			}
+			start, err := parseCueTime(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			track.Start = start
+		case "TITLE":
+			if track != nil {
+				track.Title = unquote(rest)
+			} else {
+				sheet.Title = unquote(rest)
+			}
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = unquote(rest)
+			} else {
+				sheet.Performer = unquote(rest)
+			}
+		case "REM":
+			key, value, _ := strings.Cut(rest, " ")
+			value = unquote(strings.TrimSpace(value))
+			switch strings.ToUpper(key) {
+			case "GENRE":
+				sheet.Genre = value
+			case "DATE":
+				sheet.Date = value
+			case "DISCNUMBER":
+				sheet.Disc, _ = strconv.Atoi(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Tracks without an INDEX 01 can't be located in the file
+	for _, f := range sheet.Files {
+		tracks := f.Tracks[:0]
+		for _, t := range f.Tracks {
+			if t.Start >= 0 {
+				tracks = append(tracks, t)
+			}
+		}
+		f.Tracks = tracks
+	}
+
+	return sheet, nil
+}
+
+// cueFileName extracts the file name from a FILE argument such as
+// "Album.flac" WAVE.
+func cueFileName(rest string) string {
+	if strings.HasPrefix(rest, `"`) {
+		if end := strings.Index(rest[1:], `"`); end >= 0 {
+			return rest[1 : end+1]
+		}
+	}
+
+	// Unquoted: everything up to the file type
+	if i := strings.LastIndex(rest, " "); i > 0 {
+		return rest[:i]
+	}
+	return rest
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parseCueTime parses an mm:ss:ff timestamp.
+func parseCueTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid CUE timestamp: %s", s)
+	}
+
+	var values [3]int
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid CUE timestamp: %s", s)
+		}
+		values[i] = v
+	}
+
+	frames := (values[0]*60+values[1])*cueFramesPerSecond + values[2]
+	return time.Duration(frames) * time.Second / cueFramesPerSecond, nil
+}
+
+// cueTracks splits a whole-file library entry into one virtual entry per
+// track of its CUE sheet. Tags from the sheet win over the file's own.
+func cueTracks(file *LocalFile, cue *cueFile) []*LocalFile {
+	sheet := cue.Sheet
+	year := file.Year
+	if len(sheet.Date) >= 4 {
+		if y, err := strconv.Atoi(sheet.Date[:4]); err == nil {
+			year = y
+		}
+	}
+	disc := file.DiscNumber
+	if sheet.Disc > 0 {
+		disc = sheet.Disc
+	}
+
+	tracks := make([]*LocalFile, 0, len(cue.Tracks))
+	for i, t := range cue.Tracks {
+		var end time.Duration
+		if i+1 < len(cue.Tracks) {
+			end = cue.Tracks[i+1].Start
+		}
+
+		duration := 0
+		switch {
+		case end > 0:
+			duration = int((end - t.Start).Seconds())
+		case file.Duration > 0:
+			duration = max(file.Duration-int(t.Start.Seconds()), 0)
+		}
+
+		title := t.Title
+		if title == "" {
+			title = fmt.Sprintf("Track %02d", t.Number)
+		}
+
+		track := *file
+		track.Name = fmt.Sprintf("%02d - %s", t.Number, title)
+		track.Title = title
+		track.Artist = firstNonEmpty(t.Performer, sheet.Performer, file.Artist)
+		track.Album = firstNonEmpty(sheet.Title, file.Album)
+		track.AlbumArtist = firstNonEmpty(sheet.Performer, file.AlbumArtist)
+		track.Genre = firstNonEmpty(sheet.Genre, file.Genre)
+		track.Year = year
+		track.TrackNumber = t.Number
+		track.DiscNumber = disc
+		track.Duration = duration
+		track.Start = t.Start
+		track.End = end
+		tracks = append(tracks, &track)
+	}
+
+	return tracks
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func isCueSheet(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".cue")
+}
+
+// loadCueSheet parses a CUE sheet and returns its FILE sections keyed by
+// audio path. Sections with fewer than two tracks describe ordinary
+// one-track files and are left out.
+func loadCueSheet(path string) map[string]*cueFile {
+	sheet, err := parseCueSheet(path)
+	if err != nil {
+		return nil
+	}
+
+	files := make(map[string]*cueFile)
+	for _, f := range sheet.Files {
+		if len(f.Tracks) >= 2 {
+			files[f.Path] = f
+		}
+	}
+	return files
+}
+
+// expandCue returns the entries a library file should appear as: its CUE
+// tracks if a sheet describes it, otherwise the file itself.
+func expandCue(file *LocalFile, cues map[string]*cueFile) []*LocalFile {
+	if cue, ok := cues[file.Path]; ok {
+		return cueTracks(file, cue)
+	}
+	return []*LocalFile{file}
+}
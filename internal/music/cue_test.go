@@ -0,0 +1,230 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCueTime(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"00:00:00", 0, false},
+		{"01:02:00", 62 * time.Second, false},
+		{"00:01:75", 2 * time.Second, false},
+		{"00:00:15", 200 * time.Millisecond, false},
+		{"74:59:74", 74*time.Minute + 59*time.Second + 74*time.Second/75, false},
+		{"01:02", 0, true},
+		{"aa:00:00", 0, true},
+		{"00:-1:00", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCueTime(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCueTime(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCueTime(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseCueSheet(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    cueSheet
+		files   []string
+		tracks  [][]cueTrack
+		wantErr bool
+	}{
+		{
+			name: "album",
+			content: "\xef\xbb\xbfREM GENRE \"Vocaloid\"\r\n" +
+				"REM DATE 2008\r\n" +
+				"REM DISCNUMBER 2\r\n" +
+				"PERFORMER \"supercell\"\r\n" +
+				"TITLE \"Album\"\r\n" +
+				"FILE \"Album.flac\" WAVE\r\n" +
+				"  TRACK 01 AUDIO\r\n" +
+				"    TITLE \"First\"\r\n" +
+				"    INDEX 01 00:00:00\r\n" +
+				"  TRACK 02 AUDIO\r\n" +
+				"    TITLE \"Second\"\r\n" +
+				"    PERFORMER \"Guest\"\r\n" +
+				"    INDEX 00 03:58:00\r\n" +
+				"    INDEX 01 04:00:00\r\n",
+			want:  cueSheet{Title: "Album", Performer: "supercell", Genre: "Vocaloid", Date: "2008", Disc: 2},
+			files: []string{"Album.flac"},
+			tracks: [][]cueTrack{{
+				{Number: 1, Title: "First", Start: 0},
+				{Number: 2, Title: "Second", Performer: "Guest", Start: 4 * time.Minute},
+			}},
+		},
+		{
+			name: "several files, unquoted name and track without index",
+			content: "FILE disc one.wav WAVE\n" +
+				"TRACK 1 AUDIO\n" +
+				"INDEX 01 00:00:00\n" +
+				"TRACK 2 AUDIO\n" +
+				"FILE \"sub\\two.wav\" WAVE\n" +
+				"TRACK 3 AUDIO\n" +
+				"INDEX 01 00:00:37\n",
+			files: []string{"disc one.wav", filepath.Join("sub", "two.wav")},
+			tracks: [][]cueTrack{
+				{{Number: 1, Start: 0}},
+				{{Number: 3, Start: 37 * time.Second / 75}},
+			},
+		},
+		{
+			name:    "latin-1",
+			content: "TITLE \"Caf\xe9\"\nFILE \"a.wav\" WAVE\n",
+			want:    cueSheet{Title: "Café"},
+			files:   []string{"a.wav"},
+			tracks:  [][]cueTrack{{}},
+		},
+		{
+			name:    "track before file",
+			content: "TRACK 01 AUDIO\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad timestamp",
+			content: "FILE \"a.wav\" WAVE\nTRACK 01 AUDIO\nINDEX 01 1:2\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "album.cue")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			sheet, err := parseCueSheet(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCueSheet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if sheet.Title != tt.want.Title || sheet.Performer != tt.want.Performer || sheet.Genre != tt.want.Genre ||
+				sheet.Date != tt.want.Date || sheet.Disc != tt.want.Disc {
+				t.Errorf("sheet = %+v, want %+v", *sheet, tt.want)
+			}
+
+			if len(sheet.Files) != len(tt.files) {
+				t.Fatalf("got %d files, want %d", len(sheet.Files), len(tt.files))
+			}
+			for i, file := range sheet.Files {
+				if want := filepath.Join(dir, tt.files[i]); file.Path != want {
+					t.Errorf("file %d path = %q, want %q", i, file.Path, want)
+				}
+				if file.Sheet != sheet {
+					t.Errorf("file %d doesn't point back at its sheet", i)
+				}
+				if len(file.Tracks) != len(tt.tracks[i]) {
+					t.Errorf("file %d tracks = %+v, want %+v", i, file.Tracks, tt.tracks[i])
+					continue
+				}
+				for j, track := range file.Tracks {
+					if track != tt.tracks[i][j] {
+						t.Errorf("file %d track %d = %+v, want %+v", i, j, track, tt.tracks[i][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCueTracks(t *testing.T) {
+	file := &LocalFile{
+		Path:        "/music/album.flac",
+		Artist:      "File Artist",
+		Album:       "File Album",
+		AlbumArtist: "File Album Artist",
+		Genre:       "Pop",
+		Year:        1999,
+		DiscNumber:  1,
+		Duration:    600,
+	}
+
+	tests := []struct {
+		name  string
+		sheet *cueSheet
+		cue   []cueTrack
+		want  []LocalFile
+	}{
+		{
+			name:  "sheet tags win",
+			sheet: &cueSheet{Title: "Sheet Album", Performer: "Sheet Artist", Genre: "Vocaloid", Date: "2008-03-05", Disc: 2},
+			cue: []cueTrack{
+				{Number: 1, Title: "First", Start: 0},
+				{Number: 2, Title: "Second", Performer: "Guest", Start: 4 * time.Minute},
+			},
+			want: []LocalFile{
+				{Name: "01 - First", Title: "First", Artist: "Sheet Artist", Album: "Sheet Album", AlbumArtist: "Sheet Artist",
+					Genre: "Vocaloid", Year: 2008, TrackNumber: 1, DiscNumber: 2, Duration: 240, Start: 0, End: 4 * time.Minute},
+				{Name: "02 - Second", Title: "Second", Artist: "Guest", Album: "Sheet Album", AlbumArtist: "Sheet Artist",
+					Genre: "Vocaloid", Year: 2008, TrackNumber: 2, DiscNumber: 2, Duration: 360, Start: 4 * time.Minute},
+			},
+		},
+		{
+			name:  "file tags fill in",
+			sheet: &cueSheet{Date: "n/a"},
+			cue: []cueTrack{
+				{Number: 3, Start: 90 * time.Second},
+			},
+			want: []LocalFile{
+				{Name: "03 - Track 03", Title: "Track 03", Artist: "File Artist", Album: "File Album", AlbumArtist: "File Album Artist",
+					Genre: "Pop", Year: 1999, TrackNumber: 3, DiscNumber: 1, Duration: 510, Start: 90 * time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cueTracks(file, &cueFile{Path: file.Path, Sheet: tt.sheet, Tracks: tt.cue})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d tracks, want %d", len(got), len(tt.want))
+			}
+			for i, track := range got {
+				want := tt.want[i]
+				want.Path = file.Path
+				if *track != want {
+					t.Errorf("track %d = %+v, want %+v", i, *track, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errUnknownDuration = errors.New("duration not found")
+
+// ffprobeTimeout bounds a single ffprobe run, so a file on a hung network
+// mount can't stall a scan or a command.
+const ffprobeTimeout = 15 * time.Second
+
+// probeDuration returns a file's playing time in whole seconds, or 0 if it
+// can't be determined. Containers are parsed directly where that's cheap;
+// anything else goes through ffprobe, which is killed when ctx is done.
+func probeDuration(ctx context.Context, path string) int {
+	seconds, err := parseDuration(path)
+	if err != nil || seconds <= 0 {
+		seconds, err = ffprobeDuration(ctx, path)
+		if err != nil {
+			return 0
+		}
+	}
+	return int(seconds + 0.5)
+}
+
+func parseDuration(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return containerDuration(f, info.Size(), filepath.Ext(path))
+}
+
+// containerDuration parses the duration out of the container ext names.
+// Only the parts of r holding it are read, so r may be a remote file.
+func containerDuration(r io.ReaderAt, size int64, ext string) (float64, error) {
+	switch strings.ToLower(ext) {
+	case ".flac":
+		return flacDuration(r)
+	case ".wav":
+		return wavDuration(r)
+	case ".mp3":
+		return mp3Duration(r, size)
+	case ".ogg", ".opus":
+		return oggDuration(r, size)
+	case ".m4a":
+		return mp4Duration(r, size)
+	}
+	return 0, errUnknownDuration
+}
+
+var (
+	ffprobePath string
+	ffprobeOnce sync.Once
+)
+
+func ffprobeDuration(ctx context.Context, path string) (float64, error) {
+	ffprobeOnce.Do(func() {
+		ffprobePath, _ = exec.LookPath("ffprobe")
+	})
+	if ffprobePath == "" {
+		return 0, errors.New("ffprobe not found")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ffprobeTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+}
+
+// skipID3v2 returns the offset of the first byte after an ID3v2 tag, or 0
+// if the file doesn't start with one.
+func skipID3v2(r io.ReaderAt) int64 {
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err != nil || string(header[:3]) != "ID3" {
+		return 0
+	}
+
+	size := int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 | int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f)
+	offset := 10 + size
+	if header[5]&0x10 != 0 {
+		offset += 10 // footer
+	}
+	return offset
+}
+
+func flacDuration(r io.ReaderAt) (float64, error) {
+	offset := skipID3v2(r)
+
+	// "fLaC", then the STREAMINFO block header and body
+	buf := make([]byte, 4+4+34)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return 0, err
+	}
+	if string(buf[:4]) != "fLaC" || buf[4]&0x7f != 0 {
+		return 0, errUnknownDuration
+	}
+
+	info := buf[8:]
+	sampleRate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	totalSamples := uint64(info[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || totalSamples == 0 {
+		return 0, errUnknownDuration
+	}
+
+	return float64(totalSamples) / float64(sampleRate), nil
+}
+
+func wavDuration(r io.ReaderAt) (float64, error) {
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, errUnknownDuration
+	}
+
+	var byteRate uint32
+	offset := int64(12)
+	chunk := make([]byte, 8)
+	for {
+		if _, err := r.ReadAt(chunk, offset); err != nil {
+			return 0, err
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch id {
+		case "fmt ":
+			format := make([]byte, 12)
+			if _, err := r.ReadAt(format, offset+8); err != nil {
+				return 0, err
+			}
+			byteRate = binary.LittleEndian.Uint32(format[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0, errUnknownDuration
+			}
+			return float64(size) / float64(byteRate), nil
+		}
+
+		// Chunks are padded to an even size
+		offset += 8 + size + size%2
+	}
+}
+
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1 Layer III
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2/2.5 Layer III
+	}
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG-2.5
+		{0, 0, 0},             // reserved
+		{22050, 24000, 16000}, // MPEG-2
+		{44100, 48000, 32000}, // MPEG-1
+	}
+)
+
+// mp3Duration reads the first frame header and uses its Xing/Info or VBRI
+// frame count when present, falling back to a constant bitrate estimate.
+func mp3Duration(r io.ReaderAt, size int64) (float64, error) {
+	offset := skipID3v2(r)
+
+	buf := make([]byte, 4096)
+	n, err := r.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	// Find the first frame sync of an MPEG Layer III frame
+	start := -1
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] == 0xff && buf[i+1]&0xe0 == 0xe0 && (buf[i+1]>>1)&0x03 == 0x01 {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return 0, errUnknownDuration
+	}
+
+	header := buf[start:]
+	version := (header[1] >> 3) & 0x03
+	bitrateIndex := header[2] >> 4
+	sampleRateIndex := (header[2] >> 2) & 0x03
+	channelMode := header[3] >> 6
+	if version == 1 || sampleRateIndex == 3 {
+		return 0, errUnknownDuration
+	}
+
+	sampleRate := mp3SampleRates[version][sampleRateIndex]
+	table := 0
+	samplesPerFrame := 1152
+	if version != 3 {
+		table = 1
+		samplesPerFrame = 576
+	}
+	bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+
+	// Side information size decides where a Xing/Info header would sit
+	sideInfo := 32
+	switch {
+	case version == 3 && channelMode == 3:
+		sideInfo = 17
+	case version != 3 && channelMode != 3:
+		sideInfo = 17
+	case version != 3:
+		sideInfo = 9
+	}
+
+	if xing := 4 + sideInfo; len(header) >= xing+12 {
+		tag := string(header[xing : xing+4])
+		if (tag == "Xing" || tag == "Info") && header[xing+7]&0x01 != 0 {
+			frames := binary.BigEndian.Uint32(header[xing+8 : xing+12])
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate), nil
+		}
+	}
+
+	if vbri := 4 + 32; len(header) >= vbri+18 && string(header[vbri:vbri+4]) == "VBRI" {
+		frames := binary.BigEndian.Uint32(header[vbri+14 : vbri+18])
+		return float64(frames) * float64(samplesPerFrame) / float64(sampleRate), nil
+	}
+
+	if bitrate == 0 {
+		return 0, errUnknownDuration
+	}
+
+	audioBytes := size - offset - int64(start)
+	return float64(audioBytes) * 8 / float64(bitrate), nil
+}
+
+// oggDuration divides the granule position of the last page by the stream's
+// sample rate. Opus always runs at 48kHz and reports a pre-skip to remove.
+func oggDuration(r io.ReaderAt, size int64) (float64, error) {
+	head := make([]byte, 128)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	head = head[:n]
+	if len(head) < 28 || string(head[:4]) != "OggS" {
+		return 0, errUnknownDuration
+	}
+
+	// The first packet starts after the page header and its segment table
+	packet := head[27+int(head[26]):]
+
+	var sampleRate float64
+	var preSkip uint16
+	switch {
+	case len(packet) >= 16 && bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		sampleRate = float64(binary.LittleEndian.Uint32(packet[12:16]))
+	case len(packet) >= 12 && bytes.HasPrefix(packet, []byte("OpusHead")):
+		sampleRate = 48000
+		preSkip = binary.LittleEndian.Uint16(packet[10:12])
+	default:
+		return 0, errUnknownDuration
+	}
+	if sampleRate == 0 {
+		return 0, errUnknownDuration
+	}
+
+	tailSize := int64(65536)
+	if tailSize > size {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	last := bytes.LastIndex(tail, []byte("OggS"))
+	if last < 0 || last+14 > len(tail) {
+		return 0, errUnknownDuration
+	}
+
+	granule := int64(binary.LittleEndian.Uint64(tail[last+6 : last+14]))
+	samples := granule - int64(preSkip)
+	if samples <= 0 {
+		return 0, errUnknownDuration
+	}
+
+	return float64(samples) / sampleRate, nil
+}
+
+// mp4Duration reads the timescale and duration from moov/mvhd.
+func mp4Duration(r io.ReaderAt, size int64) (float64, error) {
+	moov, moovSize, err := findMP4Box(r, 0, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+
+	mvhd, _, err := findMP4Box(r, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := r.ReadAt(buf, mvhd); err != nil {
+		return 0, err
+	}
+
+	var timescale uint32
+	var duration uint64
+	if buf[0] == 1 {
+		timescale = binary.BigEndian.Uint32(buf[20:24])
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(buf[12:16])
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	}
+	if timescale == 0 {
+		return 0, errUnknownDuration
+	}
+
+	return float64(duration) / float64(timescale), nil
+}
+
+// findMP4Box searches the boxes between start and end for the given type and
+// returns the offset and size of its body.
+func findMP4Box(r io.ReaderAt, start, end int64, boxType string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return 0, 0, errUnknownDuration
+		}
+
+		if string(header[4:8]) == boxType {
+			return offset + headerSize, size - headerSize, nil
+		}
+		offset += size
+	}
+	return 0, 0, errUnknownDuration
+}
@@ -0,0 +1,186 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestContainerDurations(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func(r io.ReaderAt, size int64) (float64, error)
+		data    []byte
+		want    float64
+		wantErr bool
+	}{
+		{"flac", ignoreSize(flacDuration), flacFile(44100, 44100*90), 90, false},
+		{"flac with id3", ignoreSize(flacDuration), append(id3Tag(50), flacFile(48000, 24000)...), 0.5, false},
+		{"flac without streaminfo", ignoreSize(flacDuration), []byte("fLaC\x04\x00\x00\x22" + string(make([]byte, 34))), 0, true},
+		{"wav", ignoreSize(wavDuration), wavFile(176400, 176400*12), 12, false},
+		{"not wav", ignoreSize(wavDuration), []byte("RIFF\x00\x00\x00\x00AVI LIST"), 0, true},
+		{"mp3 cbr", mp3Duration, append(id3Tag(100), mp3Frames(0x00, 160000)...), 10, false},
+		{"mp3 xing", mp3Duration, mp3Xing(1000), 1000 * 1152 / 44100.0, false},
+		{"not mp3", mp3Duration, make([]byte, 1000), 0, true},
+		{"ogg vorbis", oggDuration, oggFile(vorbisHead(44100), 441000), 10, false},
+		{"ogg opus", oggDuration, oggFile(opusHead(312), 48000*3+312), 3, false},
+		{"ogg unknown codec", oggDuration, oggFile([]byte("\x80theora"), 1000), 0, true},
+		{"m4a", mp4Duration, mp4File(mvhd(0, 1000, 215000), true), 215, false},
+		{"m4a 64-bit mvhd", mp4Duration, mp4File(mvhd(1, 44100, 44100*30), false), 30, false},
+		{"m4a without moov", mp4Duration, mp4Box("ftyp", []byte("M4A ")), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("duration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func ignoreSize(parse func(io.ReaderAt) (float64, error)) func(io.ReaderAt, int64) (float64, error) {
+	return func(r io.ReaderAt, _ int64) (float64, error) {
+		return parse(r)
+	}
+}
+
+// id3Tag returns an empty ID3v2 tag with size bytes of padding.
+func id3Tag(size int) []byte {
+	tag := []byte{'I', 'D', '3', 4, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(tag, make([]byte, size)...)
+}
+
+func flacFile(sampleRate, samples uint64) []byte {
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate<<4) | 0x02
+	info[13] = byte(samples>>32) & 0x0f
+	binary.BigEndian.PutUint32(info[14:18], uint32(samples))
+	return append([]byte("fLaC\x80\x00\x00\x22"), info...)
+}
+
+func wavFile(byteRate, dataSize uint32) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	b.WriteString("fmt \x10\x00\x00\x00")
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint32(format[8:12], byteRate)
+	b.Write(format)
+	// An odd-sized chunk is padded to an even size
+	b.WriteString("LIST\x03\x00\x00\x00abc\x00")
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataSize)
+	return b.Bytes()
+}
+
+// mp3Frames returns size bytes of 128 kbps, 44.1 kHz MPEG-1 Layer III
+// audio starting with a frame header.
+func mp3Frames(channelMode byte, size int) []byte {
+	data := make([]byte, size)
+	copy(data, []byte{0xff, 0xfb, 0x90, channelMode << 6})
+	return data
+}
+
+func mp3Xing(frames uint32) []byte {
+	data := mp3Frames(3, 4096)
+	// Mono MPEG-1 has 17 bytes of side information before the Xing header
+	copy(data[21:], "Xing\x00\x00\x00\x01")
+	binary.BigEndian.PutUint32(data[29:33], frames)
+	return data
+}
+
+func oggPage(granule uint64, packet []byte) []byte {
+	page := make([]byte, 27, 28+len(packet))
+	copy(page, "OggS")
+	binary.LittleEndian.PutUint64(page[6:14], granule)
+	page[26] = 1
+	page = append(page, byte(len(packet)))
+	return append(page, packet...)
+}
+
+func oggFile(head []byte, lastGranule uint64) []byte {
+	data := oggPage(0, head)
+	data = append(data, oggPage(lastGranule/2, make([]byte, 200))...)
+	return append(data, oggPage(lastGranule, make([]byte, 100))...)
+}
+
+func vorbisHead(sampleRate uint32) []byte {
+	head := make([]byte, 30)
+	copy(head, "\x01vorbis")
+	head[11] = 2
+	binary.LittleEndian.PutUint32(head[12:16], sampleRate)
+	return head
+}
+
+func opusHead(preSkip uint16) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8], head[9] = 1, 2
+	binary.LittleEndian.PutUint16(head[10:12], preSkip)
+	return head
+}
+
+func mp4Box(boxType string, body []byte) []byte {
+	box := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(box, uint32(8+len(body)))
+	copy(box[4:], boxType)
+	return append(box, body...)
+}
+
+// mvhd returns a movie header box, whose body is 100 bytes in version 0
+// and 112 in version 1.
+func mvhd(version byte, timescale uint32, duration uint64) []byte {
+	if version == 1 {
+		body := make([]byte, 112)
+		body[0] = 1
+		binary.BigEndian.PutUint32(body[20:24], timescale)
+		binary.BigEndian.PutUint64(body[24:32], duration)
+		return mp4Box("mvhd", body)
+	}
+	body := make([]byte, 100)
+	binary.BigEndian.PutUint32(body[12:16], timescale)
+	binary.BigEndian.PutUint32(body[16:20], uint32(duration))
+	return mp4Box("mvhd", body)
+}
+
+// mp4File puts moov after the media data if moovLast is set, as files
+// that weren't optimized for streaming have it.
+func mp4File(mvhd []byte, moovLast bool) []byte {
+	moov := mp4Box("moov", append(mp4Box("udta", nil), mvhd...))
+	mdat := mp4Box("mdat", make([]byte, 1000))
+
+	data := mp4Box("ftyp", []byte("M4A "))
+	if moovLast {
+		return append(append(data, mdat...), moov...)
+	}
+	return append(append(data, moov...), mdat...)
+}
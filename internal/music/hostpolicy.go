@@ -0,0 +1,271 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrHostBlocked = errors.New("that host is not allowed")
+
+// HostPolicy decides which hosts the bot fetches audio from. Entries are
+// host names, which match their subdomains too, IP addresses or CIDR
+// ranges.
+type HostPolicy struct {
+	Allow        []string // If set, only these hosts are allowed
+	Deny         []string
+	AllowPrivate bool // Allow private, loopback and link-local addresses
+}
+
+// trustedHosts serve Discord attachments, which are played whatever the
+// allow list says.
+var trustedHosts = hostRules{names: []string{"cdn.discordapp.com", "media.discordapp.net"}}
+
+// blockedPrefixes are ranges netip doesn't count as private but which are
+// just as unreachable from the internet.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+type hostRules struct {
+	names    []string
+	prefixes []netip.Prefix
+}
+
+func parseHostRules(entries []string) (hostRules, error) {
+	var rules hostRules
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+
+		switch {
+		case entry == "":
+			continue
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return rules, fmt.Errorf("invalid range %q: %w", entry, err)
+			}
+			rules.prefixes = append(rules.prefixes, prefix.Masked())
+		default:
+			if addr, err := netip.ParseAddr(entry); err == nil {
+				rules.prefixes = append(rules.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			rules.names = append(rules.names, entry)
+		}
+	}
+	return rules, nil
+}
+
+func (r hostRules) empty() bool {
+	return len(r.names) == 0 && len(r.prefixes) == 0
+}
+
+func (r hostRules) matchName(host string) bool {
+	for _, name := range r.names {
+		if host == name || strings.HasSuffix(host, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r hostRules) matchAddr(addr netip.Addr) bool {
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostFilter checks URLs and connections against a HostPolicy. Everything
+// that fetches from the web is given one, and the helper processes that
+// fetch for the bot go through its proxy.
+type HostFilter struct {
+	allow        hostRules
+	deny         hostRules
+	allowPrivate bool
+
+	// client has no overall timeout, since live responses never end;
+	// callers bound requests with their contexts.
+	client *http.Client
+
+	proxyOnce sync.Once
+	proxyURL  string
+	proxyErr  error
+}
+
+// NewHostFilter compiles a host policy. The zero HostPolicy blocks private
+// addresses and allows everything else.
+func NewHostFilter(policy HostPolicy) (*HostFilter, error) {
+	allow, err := parseHostRules(policy.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseHostRules(policy.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &HostFilter{allow: allow, deny: deny, allowPrivate: policy.AllowPrivate}
+	f.client = &http.Client{Transport: f.transport(ProbeTimeout)}
+	return f, nil
+}
+
+// CheckURL returns ErrHostBlocked if rawURL points at a host the policy
+// doesn't allow, looking the host up so that names pointing at private
+// addresses are caught too. Input without a host, like local paths and
+// searches, passes.
+func (f *HostFilter) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	return f.checkHost(ctx, u.Hostname())
+}
+
+func (f *HostFilter) checkHost(ctx context.Context, host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if f.deny.matchName(host) {
+		return fmt.Errorf("%w: %s", ErrHostBlocked, host)
+	}
+
+	addrs, err := lookupHost(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", host, err)
+	}
+
+	listed := f.allow.empty() || f.allow.matchName(host) || trustedHosts.matchName(host)
+	for _, addr := range addrs {
+		if (!listed && !f.allow.matchAddr(addr)) || !f.addrAllowed(addr) {
+			return fmt.Errorf("%w: %s", ErrHostBlocked, host)
+		}
+	}
+	return nil
+}
+
+// addrAllowed checks an address against the deny list and the private
+// ranges. Explicitly allowed ranges are let through even if private.
+func (f *HostFilter) addrAllowed(addr netip.Addr) bool {
+	if f.deny.matchAddr(addr) {
+		return false
+	}
+	if f.allowPrivate || f.allow.matchAddr(addr) {
+		return true
+	}
+	return !privateAddr(addr)
+}
+
+func privateAddr(addr netip.Addr) bool {
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	return addrs, nil
+}
+
+// checkDial refuses connections to blocked addresses. It runs after DNS,
+// so a host that passed CheckURL can't be rebound to a private address
+// before the connection is made.
+func (f *HostFilter) checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !f.addrAllowed(addr.Unmap()) {
+		return fmt.Errorf("%w: %s", ErrHostBlocked, host)
+	}
+	return nil
+}
+
+// dialer connects only to addresses the policy allows.
+func (f *HostFilter) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   f.checkDial,
+	}
+}
+
+// policyTransport checks every request against the host policy before
+// sending it. Redirects go through the transport again, so they are
+// checked as well.
+type policyTransport struct {
+	filter *HostFilter
+	base   http.RoundTripper
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.filter.CheckURL(req.Context(), req.URL.String()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// transport returns a transport that only talks to hosts the policy
+// allows. It doesn't use proxies, since the policy has to see the
+// addresses it connects to.
+func (f *HostFilter) transport(headerTimeout time.Duration) http.RoundTripper {
+	return &policyTransport{filter: f, base: &http.Transport{
+		DialContext:           f.dialer().DialContext,
+		ResponseHeaderTimeout: headerTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	}}
+}
@@ -0,0 +1,354 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestParseHostRules(t *testing.T) {
+	tests := []struct {
+		entries  []string
+		names    []string
+		prefixes []string
+		wantErr  bool
+	}{
+		{[]string{"Example.COM.", "*.cdn.example.net", ".sub.example.org", " "}, []string{"example.com", "cdn.example.net", "sub.example.org"}, nil, false},
+		{[]string{"192.168.1.7", "10.1.2.3/8", "::ffff:1.2.3.4", "fd00::/8"}, nil, []string{"192.168.1.7/32", "10.0.0.0/8", "1.2.3.4/32", "fd00::/8"}, false},
+		{[]string{"10.0.0.0/33"}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		rules, err := parseHostRules(tt.entries)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHostRules(%q) error = %v, wantErr %v", tt.entries, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if len(rules.names) != len(tt.names) {
+			t.Errorf("parseHostRules(%q) names = %q, want %q", tt.entries, rules.names, tt.names)
+		} else {
+			for i := range rules.names {
+				if rules.names[i] != tt.names[i] {
+					t.Errorf("parseHostRules(%q) names = %q, want %q", tt.entries, rules.names, tt.names)
+					break
+				}
+			}
+		}
+
+		var prefixes []string
+		for _, prefix := range rules.prefixes {
+			prefixes = append(prefixes, prefix.String())
+		}
+		if len(prefixes) != len(tt.prefixes) {
+			t.Errorf("parseHostRules(%q) prefixes = %q, want %q", tt.entries, prefixes, tt.prefixes)
+			continue
+		}
+		for i := range prefixes {
+			if prefixes[i] != tt.prefixes[i] {
+				t.Errorf("parseHostRules(%q) prefixes = %q, want %q", tt.entries, prefixes, tt.prefixes)
+				break
+			}
+		}
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	rules, _ := parseHostRules([]string{"example.com", "sndcdn.com"})
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"a.b.example.com", true},
+		{"notexample.com", false},
+		{"example.com.evil.net", false},
+		{"cf-media.sndcdn.com", true},
+		{"com", false},
+	}
+
+	for _, tt := range tests {
+		if got := rules.matchName(tt.host); got != tt.want {
+			t.Errorf("matchName(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestPrivateAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"10.0.0.1", true},
+		{"172.16.5.4", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // Cloud metadata
+		{"100.64.0.1", true},      // Carrier-grade NAT
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"ff02::1", true},
+		{"8.8.8.8", false},
+		{"100.128.0.1", false},
+		{"172.32.0.1", false},
+		{"2606:4700::1111", false},
+	}
+
+	for _, tt := range tests {
+		if got := privateAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("privateAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy HostPolicy
+		host   string
+		want   bool // Allowed
+	}{
+		{"public address", HostPolicy{}, "8.8.8.8", true},
+		{"loopback", HostPolicy{}, "127.0.0.1", false},
+		{"localhost", HostPolicy{}, "localhost", false},
+		{"mapped loopback", HostPolicy{}, "::ffff:127.0.0.1", false},
+		{"metadata", HostPolicy{}, "169.254.169.254", false},
+		{"private allowed", HostPolicy{AllowPrivate: true}, "192.168.1.10", true},
+		{"private range allowed", HostPolicy{Allow: []string{"192.168.1.0/24"}}, "192.168.1.10", true},
+		{"outside allowed range", HostPolicy{Allow: []string{"192.168.1.0/24"}}, "192.168.2.10", false},
+		{"not on allow list", HostPolicy{Allow: []string{"example.com"}}, "8.8.8.8", false},
+		{"denied address", HostPolicy{Deny: []string{"8.8.8.0/24"}}, "8.8.8.8", false},
+		{"denied name", HostPolicy{Deny: []string{"localhost"}}, "localhost", false},
+		{"deny beats allow private", HostPolicy{AllowPrivate: true, Deny: []string{"10.0.0.0/8"}}, "10.1.1.1", false},
+		{"allowed name on private address", HostPolicy{Allow: []string{"localhost"}}, "localhost", false},
+		{"allowed name with private allowed", HostPolicy{Allow: []string{"localhost"}, AllowPrivate: true}, "localhost", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewHostFilter(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = filter.checkHost(context.Background(), tt.host)
+			if got := err == nil; got != tt.want {
+				t.Errorf("checkHost(%s) = %v, want allowed %v", tt.host, err, tt.want)
+			}
+			if err != nil && !errors.Is(err, ErrHostBlocked) {
+				t.Errorf("checkHost(%s) = %v, want ErrHostBlocked", tt.host, err)
+			}
+		})
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"http://127.0.0.1:8080/stream", false},
+		{"https://[::1]/a.mp3", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"https://8.8.8.8/a.mp3", true},
+		{"/music/song.flac", true},
+		{"ytsearch:miku", true},
+	}
+
+	filter, err := NewHostFilter(HostPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		err := filter.CheckURL(context.Background(), tt.input)
+		if got := err == nil; got != tt.want {
+			t.Errorf("CheckURL(%s) = %v, want allowed %v", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestCheckDial(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  HostPolicy
+		address string
+		want    bool
+	}{
+		{"public", HostPolicy{}, "8.8.8.8:443", true},
+		{"loopback", HostPolicy{}, "127.0.0.1:80", false},
+		{"loopback v6", HostPolicy{}, "[::1]:80", false},
+		{"mapped private", HostPolicy{}, "[::ffff:10.0.0.1]:80", false},
+		{"private network", HostPolicy{}, "192.168.0.1:80", false},
+		{"private allowed", HostPolicy{AllowPrivate: true}, "192.168.0.1:80", true},
+		{"range allowed", HostPolicy{Allow: []string{"192.168.0.0/16"}}, "192.168.0.1:80", true},
+		{"denied", HostPolicy{Deny: []string{"8.8.8.8"}}, "8.8.8.8:443", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewHostFilter(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = filter.checkDial("tcp", tt.address, nil)
+			if got := err == nil; got != tt.want {
+				t.Errorf("checkDial(%s) = %v, want allowed %v", tt.address, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			// Into a name the deny list blocks
+			http.Redirect(w, r, "http://localhost:"+r.URL.Port()+"/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("audio"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	tests := []struct {
+		name   string
+		policy HostPolicy
+		url    string
+		want   bool
+	}{
+		{"private blocked", HostPolicy{}, server.URL, false},
+		{"private allowed", HostPolicy{AllowPrivate: true}, server.URL, true},
+		{"redirect to denied host", HostPolicy{AllowPrivate: true, Deny: []string{"localhost"}}, server.URL + "/redirect", false},
+		{"name allowed but address private", HostPolicy{Allow: []string{"localhost"}}, "http://localhost:" + port, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewHostFilter(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := filter.client.Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if got := err == nil; got != tt.want {
+				t.Errorf("GET %s = %v, want allowed %v", tt.url, err, tt.want)
+			}
+			if err != nil && !errors.Is(err, ErrHostBlocked) {
+				t.Errorf("GET %s = %v, want ErrHostBlocked", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestPolicyProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://localhost:"+r.URL.Port()+"/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("audio"))
+	}))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure audio"))
+	}))
+	defer tlsServer.Close()
+
+	tests := []struct {
+		name       string
+		policy     HostPolicy
+		url        string
+		wantStatus int // 0 if the request should fail
+		wantBody   string
+	}{
+		{"private blocked", HostPolicy{}, server.URL, http.StatusForbidden, ""},
+		{"private allowed", HostPolicy{AllowPrivate: true}, server.URL, http.StatusOK, "audio"},
+		{"redirect passed on", HostPolicy{AllowPrivate: true}, server.URL + "/redirect", http.StatusFound, ""},
+		{"denied host", HostPolicy{AllowPrivate: true, Deny: []string{"127.0.0.1"}}, server.URL, http.StatusForbidden, ""},
+		{"tunnel allowed", HostPolicy{AllowPrivate: true}, tlsServer.URL, http.StatusOK, "secure audio"},
+		{"tunnel blocked", HostPolicy{}, tlsServer.URL, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewHostFilter(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy, err := filter.proxy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxyURL, _ := url.Parse(proxy)
+
+			// Like yt-dlp: a client that trusts the proxy and handles
+			// redirects itself
+			transport := tlsServer.Client().Transport.(*http.Transport).Clone()
+			transport.Proxy = http.ProxyURL(proxyURL)
+			client := &http.Client{
+				Transport:     transport,
+				CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			}
+
+			resp, err := client.Get(tt.url)
+			if tt.wantStatus == 0 {
+				if err == nil {
+					resp.Body.Close()
+					t.Errorf("GET %s through proxy = %s, want error", tt.url, resp.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET %s through proxy: %v", tt.url, err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("GET %s through proxy = %s, want %d", tt.url, resp.Status, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("GET %s through proxy body = %q, want %q", tt.url, body, tt.wantBody)
+			}
+		})
+	}
+}
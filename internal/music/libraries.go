@@ -0,0 +1,470 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Libraries holds the configured library roots and which guilds may see
+// each of them.
+type Libraries struct {
+	roots []*libraryRoot
+}
+
+type libraryRoot struct {
+	lib    *Library
+	guilds map[string]bool // Empty means every guild
+}
+
+func NewLibraries() *Libraries {
+	return &Libraries{}
+}
+
+// Add registers a library root. guildIDs limits which guilds can see it;
+// with none given, every guild can.
+func (ls *Libraries) Add(lib *Library, guildIDs []string) {
+	guilds := make(map[string]bool, len(guildIDs))
+	for _, id := range guildIDs {
+		guilds[id] = true
+	}
+	ls.roots = append(ls.roots, &libraryRoot{lib: lib, guilds: guilds})
+}
+
+// All returns every library root in configuration order.
+func (ls *Libraries) All() []*Library {
+	libs := make([]*Library, len(ls.roots))
+	for i, root := range ls.roots {
+		libs[i] = root.lib
+	}
+	return libs
+}
+
+// ForGuild returns the library roots visible to a guild, or nil if it can
+// see none. It is safe to call on a nil *Libraries.
+func (ls *Libraries) ForGuild(guildID string) *GuildLibrary {
+	if ls == nil {
+		return nil
+	}
+
+	var libs []*Library
+	for _, root := range ls.roots {
+		if len(root.guilds) == 0 || root.guilds[guildID] {
+			libs = append(libs, root.lib)
+		}
+	}
+
+	if len(libs) == 0 {
+		return nil
+	}
+	return &GuildLibrary{libs: libs}
+}
+
+// ResolvePath checks a local path against the roots guildID can see; see
+// GuildLibrary.ResolvePath. It is safe to call on a nil *Libraries, which
+// allows no paths at all.
+func (ls *Libraries) ResolvePath(guildID, path string) (string, error) {
+	g := ls.ForGuild(guildID)
+	if g == nil {
+		return "", fmt.Errorf("%w: %s", ErrOutsideLibrary, path)
+	}
+	return g.ResolvePath(path)
+}
+
+// Watch starts watching every library root.
+func (ls *Libraries) Watch() {
+	for _, root := range ls.roots {
+		root.lib.Watch()
+	}
+}
+
+// Close stops watching every library root.
+func (ls *Libraries) Close() {
+	for _, root := range ls.roots {
+		root.lib.Close()
+	}
+}
+
+// GetTotalFiles counts the files in every library root.
+func (ls *Libraries) GetTotalFiles() int {
+	total := 0
+	for _, root := range ls.roots {
+		total += root.lib.GetTotalFiles()
+	}
+	return total
+}
+
+// GuildLibrary is the combined view of the library roots one guild can
+// see. When it spans several roots, folders are named "root:folder"; a
+// plain folder name still works as long as only one root has it.
+type GuildLibrary struct {
+	libs []*Library
+}
+
+// rootSeparator joins a root name and a folder in qualified folder names.
+const rootSeparator = ":"
+
+func (g *GuildLibrary) qualified() bool {
+	return len(g.libs) > 1
+}
+
+// FolderName returns the name a folder is shown and addressed by.
+func (g *GuildLibrary) FolderName(root, folder string) string {
+	if !g.qualified() {
+		return folder
+	}
+	return root + rootSeparator + folder
+}
+
+// Location returns the name of the folder a file is in.
+func (g *GuildLibrary) Location(file *LocalFile) string {
+	return g.FolderName(file.Root, file.Folder)
+}
+
+// resolveFolder finds the library root holding a folder, accepting both
+// qualified and plain folder names.
+func (g *GuildLibrary) resolveFolder(folder string) (*Library, string, error) {
+	if name, rest, ok := strings.Cut(folder, rootSeparator); ok {
+		for _, lib := range g.libs {
+			if lib.name == name && lib.HasFolder(rest) {
+				return lib, rest, nil
+			}
+		}
+	}
+
+	var found []*Library
+	for _, lib := range g.libs {
+		if lib.HasFolder(folder) {
+			found = append(found, lib)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, "", fmt.Errorf("folder not found: %s", folder)
+	case 1:
+		return found[0], folder, nil
+	}
+
+	names := make([]string, len(found))
+	for i, lib := range found {
+		names[i] = g.FolderName(lib.name, folder)
+	}
+	return nil, "", fmt.Errorf("folder %s exists in several libraries, use one of: %s", folder, strings.Join(names, ", "))
+}
+
+// ResolvePath follows symlinks in path and returns the real file it points
+// at, provided that file sits inside one of the roots this guild can see.
+// Anything else, including a missing file, is ErrOutsideLibrary.
+func (g *GuildLibrary) ResolvePath(path string) (string, error) {
+	roots := make([]string, len(g.libs))
+	for i, lib := range g.libs {
+		roots[i] = lib.rootPath
+	}
+	return resolveWithin(path, roots)
+}
+
+// FileAtPath finds the library entry for a path given by a user, once it
+// has checked the path resolves inside one of the guild's roots.
+func (g *GuildLibrary) FileAtPath(path string) (*LocalFile, error) {
+	resolved, err := g.ResolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lib := range g.libs {
+		rel, ok := withinRoot(lib.rootPath, resolved)
+		if !ok {
+			continue
+		}
+		if file := lib.FileByPath(filepath.Join(lib.rootPath, rel), 0); file != nil {
+			return file, nil
+		}
+	}
+	return nil, fmt.Errorf("file not found: %s", path)
+}
+
+func (g *GuildLibrary) GetFolders() []string {
+	var folders []string
+	for _, lib := range g.libs {
+		for _, folder := range lib.GetFolders() {
+			folders = append(folders, g.FolderName(lib.name, folder))
+		}
+	}
+	return folders
+}
+
+func (g *GuildLibrary) GetFiles(folder string) []*LocalFile {
+	lib, key, err := g.resolveFolder(folder)
+	if err != nil {
+		return []*LocalFile{}
+	}
+	return lib.GetFiles(key)
+}
+
+func (g *GuildLibrary) GetAllFiles() []*LocalFile {
+	var files []*LocalFile
+	for _, lib := range g.libs {
+		files = append(files, lib.GetAllFiles()...)
+	}
+	return files
+}
+
+func (g *GuildLibrary) GetTotalFiles() int {
+	total := 0
+	for _, lib := range g.libs {
+		total += lib.GetTotalFiles()
+	}
+	return total
+}
+
+// FileByPath finds a file in any visible root, like Library.FileByPath.
+func (g *GuildLibrary) FileByPath(path string, start time.Duration) *LocalFile {
+	for _, lib := range g.libs {
+		if file := lib.FileByPath(path, start); file != nil {
+			return file
+		}
+	}
+	return nil
+}
+
+func (g *GuildLibrary) HasFolder(folder string) bool {
+	_, _, err := g.resolveFolder(folder)
+	return err == nil
+}
+
+func (g *GuildLibrary) GetFileByFolderAndName(folder, name string) (*LocalFile, error) {
+	lib, key, err := g.resolveFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	return lib.GetFileByFolderAndName(key, name)
+}
+
+func (g *GuildLibrary) FolderFiles(folder string, recursive bool, order string) ([]*LocalFile, error) {
+	lib, key, err := g.resolveFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	return lib.FolderFiles(key, recursive, order)
+}
+
+// Search ranks the files of every visible root together.
+func (g *GuildLibrary) Search(query string) []SearchResult {
+	var results []SearchResult
+	for _, lib := range g.libs {
+		results = append(results, lib.Search(query)...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+func (g *GuildLibrary) SearchByName(query string) []*LocalFile {
+	results := g.Search(query)
+	files := make([]*LocalFile, len(results))
+	for i, result := range results {
+		files[i] = result.File
+	}
+	return files
+}
+
+func (g *GuildLibrary) FindFile(query string) (*LocalFile, error) {
+	file, err := pickResult(g.Search(query), query)
+	if err != nil && !isAmbiguous(err) {
+		return nil, fmt.Errorf("file not found: %s", query)
+	}
+	return file, err
+}
+
+func (g *GuildLibrary) Artists() []*ArtistSummary {
+	merged := make(map[string]*ArtistSummary)
+	for _, lib := range g.libs {
+		for _, artist := range lib.Artists() {
+			key := normalizeKey(artist.Name)
+			if existing, ok := merged[key]; ok {
+				existing.Albums += artist.Albums
+				existing.Tracks += artist.Tracks
+				continue
+			}
+			copied := *artist
+			merged[key] = &copied
+		}
+	}
+
+	artists := make([]*ArtistSummary, 0, len(merged))
+	for _, artist := range merged {
+		artists = append(artists, artist)
+	}
+	sort.Slice(artists, func(i, j int) bool {
+		return normalizeKey(artists[i].Name) < normalizeKey(artists[j].Name)
+	})
+	return artists
+}
+
+func (g *GuildLibrary) AlbumsByArtist(artist string) []*Album {
+	var albums []*Album
+	for _, lib := range g.libs {
+		albums = append(albums, lib.AlbumsByArtist(artist)...)
+	}
+	sortAlbums(albums)
+	return albums
+}
+
+func (g *GuildLibrary) FindAlbums(name string) []*Album {
+	// Exact matches in any root beat partial matches in the others
+	var exact, partial []*Album
+	key := normalizeKey(name)
+	for _, lib := range g.libs {
+		for _, album := range lib.FindAlbums(name) {
+			if normalizeKey(album.Name) == key {
+				exact = append(exact, album)
+			} else {
+				partial = append(partial, album)
+			}
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+func (g *GuildLibrary) Genres() []*GenreSummary {
+	merged := make(map[string]*GenreSummary)
+	for _, lib := range g.libs {
+		for _, genre := range lib.Genres() {
+			key := normalizeKey(genre.Name)
+			if existing, ok := merged[key]; ok {
+				existing.Tracks += genre.Tracks
+				continue
+			}
+			copied := *genre
+			merged[key] = &copied
+		}
+	}
+
+	genres := make([]*GenreSummary, 0, len(merged))
+	for _, genre := range merged {
+		genres = append(genres, genre)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		return normalizeKey(genres[i].Name) < normalizeKey(genres[j].Name)
+	})
+	return genres
+}
+
+func (g *GuildLibrary) FilesByGenre(genre string) []*LocalFile {
+	var files []*LocalFile
+	for _, lib := range g.libs {
+		files = append(files, lib.FilesByGenre(genre)...)
+	}
+	sortByArtistAndAlbum(files)
+	return files
+}
+
+func (g *GuildLibrary) FilesByYear(year int) []*LocalFile {
+	var files []*LocalFile
+	for _, lib := range g.libs {
+		files = append(files, lib.FilesByYear(year)...)
+	}
+	sortByArtistAndAlbum(files)
+	return files
+}
+
+func (g *GuildLibrary) Playlists() []*Playlist {
+	var playlists []*Playlist
+	for _, lib := range g.libs {
+		playlists = append(playlists, lib.Playlists()...)
+	}
+	sortPlaylists(playlists)
+	return playlists
+}
+
+func (g *GuildLibrary) FindPlaylists(name string) []*Playlist {
+	var exact, partial []*Playlist
+	key := normalizeKey(name)
+	for _, lib := range g.libs {
+		for _, playlist := range lib.FindPlaylists(name) {
+			if normalizeKey(playlist.Name) == key {
+				exact = append(exact, playlist)
+			} else {
+				partial = append(partial, playlist)
+			}
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+// ResolvePlaylist resolves a playlist against the root it belongs to.
+func (g *GuildLibrary) ResolvePlaylist(playlist *Playlist) ([]*LocalFile, []string, error) {
+	for _, lib := range g.libs {
+		if lib.name == playlist.Root {
+			return lib.ResolvePlaylist(playlist)
+		}
+	}
+	return nil, nil, fmt.Errorf("playlist not found: %s", playlist.Name)
+}
+
+// PlaylistLocation returns the name of the folder a playlist is in.
+func (g *GuildLibrary) PlaylistLocation(playlist *Playlist) string {
+	return g.FolderName(playlist.Root, playlist.Folder)
+}
+
+// Scan rescans every visible root and adds up the results.
+func (g *GuildLibrary) Scan(ctx context.Context, progress func(ScanProgress)) (*ScanResult, error) {
+	total := &ScanResult{}
+	for _, lib := range g.libs {
+		done := *total
+		result, err := lib.Scan(ctx, func(p ScanProgress) {
+			if progress != nil {
+				progress(ScanProgress{
+					Total:     done.Total + p.Total,
+					Changed:   done.Updated + done.Added + p.Changed,
+					Processed: done.Updated + done.Added + p.Processed,
+				})
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("library %s: %w", lib.name, err)
+		}
+
+		total.Total += result.Total
+		total.Added += result.Added
+		total.Updated += result.Updated
+		total.Removed += result.Removed
+		total.Elapsed += result.Elapsed
+	}
+	return total, nil
+}
@@ -22,14 +22,19 @@
 package music
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"miku_bot/internal/database"
 
 	"github.com/dhowden/tag"
 )
@@ -38,20 +43,77 @@ type LocalFile struct {
 	Name        string
 	Path        string
 	Folder      string
-	Duration    int    // Duration in seconds (can be extracted from metadata later)
+	Root        string // Name of the library root the file belongs to
+	Duration    int    // Duration in seconds
 	AlbumArt    string // Path to cached album art file
 	Title       string // Track title from metadata
 	Artist      string // Artist from metadata
 	Album       string // Album from metadata
+	AlbumArtist string // Album artist from metadata
+	Genre       string // Genre from metadata
+	Year        int    // Release year from metadata
+	TrackNumber int    // Track number within the disc
+	DiscNumber  int    // Disc number within the album
+
+	// Start and End select a slice of the file for tracks from a CUE
+	// sheet; End is zero when the track runs to the end of the file
+	Start time.Duration
+	End   time.Duration
+
+	size    int64 // File size when indexed
+	modTime int64 // Modification time when indexed (Unix nanoseconds)
 }
 
 type Library struct {
-	rootPath   string
-	files      map[string][]*LocalFile // folder -> files
-	artCache   string                  // Directory for cached album art
-	mu         sync.RWMutex
+	name      string
+	rootPath  string
+	files     map[string][]*LocalFile // folder -> files
+	byPath    map[string][]*LocalFile // path -> entries, several for CUE sheet tracks
+	playlists map[string]*Playlist    // path -> playlist
+	cues      map[string]*cueFile     // audio path -> CUE sheet section, found by Scan
+	index     *browseIndex            // Browse and search index, nil when stale
+	art       *ArtCache               // Shared, content-addressed album art store
+	sidecars  map[string]string       // directory -> cached cover image, per scan
+	sidecarMu sync.Mutex
+	db        *database.Database // Persistent index of scanned files
+	mu        sync.RWMutex
+	scanMu    sync.Mutex // Only one scan runs at a time
+	// ctx is cancelled by Close, which stops watching the music folder and
+	// kills any ffprobe a running scan is waiting on
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ScanProgress reports how far a running scan has got. Total and Changed
+// are known once the directory walk finishes; Processed counts the changed
+// files whose tags have been read so far.
+type ScanProgress struct {
+	Total     int
+	Changed   int
+	Processed int
 }
 
+// ScanResult summarises a completed scan.
+type ScanResult struct {
+	Total   int
+	Added   int
+	Updated int
+	Removed int
+	Elapsed time.Duration
+}
+
+// indexBatchSize is how many changed files are written to the index per
+// transaction during a scan.
+const indexBatchSize = 500
+
+// scanVersion is bumped whenever readRecord starts extracting something new;
+// index entries from older versions are re-read on the next scan.
+//
+//	1: durations
+//	2: album artist, genre, year, track and disc numbers
+//	3: content-addressed album art cache, cover image sidecars
+const scanVersion = 3
+
 var supportedExtensions = map[string]bool{
 	".mp3":  true,
 	".flac": true,
@@ -61,9 +123,10 @@ var supportedExtensions = map[string]bool{
 	".opus": true,
 	".aac":  true,
 	".wma":  true,
+	".ape":  true,
 }
 
-func NewLibrary(rootPath string) (*Library, error) {
+func NewLibrary(name, rootPath string, db *database.Database, art *ArtCache) (*Library, error) {
 	if rootPath == "" {
 		return nil, fmt.Errorf("music folder path is not configured")
 	}
@@ -73,27 +136,124 @@ func NewLibrary(rootPath string) (*Library, error) {
 		return nil, fmt.Errorf("music folder does not exist: %s", rootPath)
 	}
 
-	// Create cache directory for album art
-	cacheDir := filepath.Join(os.TempDir(), "miku_bot_albumart")
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create album art cache directory: %w", err)
-	}
-
+	ctx, cancel := context.WithCancel(context.Background())
 	lib := &Library{
-		rootPath: rootPath,
-		files:    make(map[string][]*LocalFile),
-		artCache: cacheDir,
+		name:      name,
+		rootPath:  rootPath,
+		files:     make(map[string][]*LocalFile),
+		byPath:    make(map[string][]*LocalFile),
+		playlists: make(map[string]*Playlist),
+		cues:      make(map[string]*cueFile),
+		art:       art,
+		sidecars:  make(map[string]string),
+		db:        db,
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 
-	// Scan the directory
-	if err := lib.Scan(); err != nil {
+	if err := lib.loadIndex(); err != nil {
 		return nil, err
 	}
 
+	// With nothing indexed yet there is nothing to serve, so scan up front.
+	// Otherwise serve the stored index and catch up in the background.
+	if lib.GetTotalFiles() == 0 {
+		if _, err := lib.Scan(ctx, nil); err != nil {
+			cancel()
+			return nil, err
+		}
+		return lib, nil
+	}
+
+	go func() {
+		result, err := lib.Scan(ctx, nil)
+		if err != nil {
+			log.Printf("Background rescan of library %q failed: %v", lib.name, err)
+			return
+		}
+		log.Printf("Local music library %q rescanned in %s: %d files (%d added, %d updated, %d removed)",
+			lib.name, result.Elapsed.Round(time.Millisecond), result.Total, result.Added, result.Updated, result.Removed)
+	}()
+
 	return lib, nil
 }
 
-func (l *Library) extractMetadata(filePath string) (title, artist, album string, artPath string) {
+// Name returns the name of the library root.
+func (l *Library) Name() string {
+	return l.name
+}
+
+// loadIndex fills the library from the persistent index without touching
+// the files themselves.
+func (l *Library) loadIndex() error {
+	records, err := l.db.GetLibraryFiles()
+	if err != nil {
+		return fmt.Errorf("failed to load library index: %w", err)
+	}
+
+	files := make(map[string][]*LocalFile)
+	for _, record := range records {
+		if file := l.localFile(record); file != nil {
+			files[file.Folder] = append(files[file.Folder], file)
+		}
+	}
+
+	l.mu.Lock()
+	l.files = files
+	l.byPath = indexPaths(files)
+	l.index = nil
+	l.mu.Unlock()
+
+	return nil
+}
+
+// localFile builds a library entry from an index record, or returns nil if
+// the record lies outside this library's root.
+func (l *Library) localFile(record *database.LibraryFile) *LocalFile {
+	relPath, err := filepath.Rel(l.rootPath, record.Path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	// Get folder name (use "root" if file is in root directory)
+	folder := filepath.Dir(relPath)
+	if folder == "." {
+		folder = "root"
+	}
+
+	return &LocalFile{
+		Name:        filepath.Base(record.Path),
+		Path:        record.Path,
+		Folder:      folder,
+		Root:        l.name,
+		Duration:    record.Duration,
+		AlbumArt:    record.AlbumArt,
+		Title:       record.Title,
+		Artist:      record.Artist,
+		Album:       record.Album,
+		AlbumArtist: record.AlbumArtist,
+		Genre:       record.Genre,
+		Year:        record.Year,
+		TrackNumber: record.TrackNumber,
+		DiscNumber:  record.DiscNumber,
+		size:        record.Size,
+		modTime:     record.ModTime,
+	}
+}
+
+// trackTags holds the metadata read from a file's tags.
+type trackTags struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Genre       string
+	Year        int
+	TrackNumber int
+	DiscNumber  int
+}
+
+func (l *Library) extractMetadata(filePath string) (tags trackTags, artPath string) {
 	f, err := os.Open(filePath)
 	if err != nil {
 		return
@@ -103,58 +263,96 @@ func (l *Library) extractMetadata(filePath string) (title, artist, album string,
 	m, err := tag.ReadFrom(f)
 	if err != nil {
 		// If metadata reading fails, use filename as title
-		title = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+		tags.Title = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+		artPath = l.sidecarArt(filepath.Dir(filePath))
 		return
 	}
 
-	title = m.Title()
-	artist = m.Artist()
-	album = m.Album()
+	tags = trackTags{
+		Title:       strings.TrimSpace(m.Title()),
+		Artist:      strings.TrimSpace(m.Artist()),
+		Album:       strings.TrimSpace(m.Album()),
+		AlbumArtist: strings.TrimSpace(m.AlbumArtist()),
+		Genre:       strings.TrimSpace(m.Genre()),
+		Year:        m.Year(),
+	}
+	tags.TrackNumber, _ = m.Track()
+	tags.DiscNumber, _ = m.Disc()
 
 	// If title is empty, use filename
-	if title == "" {
-		title = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	if tags.Title == "" {
+		tags.Title = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
 	}
 
-	// Extract album art if available
-	picture := m.Picture()
-	if picture != nil && len(picture.Data) > 0 {
-		// Create a hash of the file path for unique cache filename
-		hash := md5.Sum([]byte(filePath))
-		hashStr := hex.EncodeToString(hash[:])
+	// Prefer embedded art over cover images next to the file
+	if picture := m.Picture(); picture != nil {
+		artPath = l.art.Store(picture.Data)
+	}
+	if artPath == "" {
+		artPath = l.sidecarArt(filepath.Dir(filePath))
+	}
 
-		// Determine file extension from MIME type
-		ext := ".jpg"
-		if strings.Contains(picture.MIMEType, "png") {
-			ext = ".png"
-		}
+	return
+}
 
-		artPath = filepath.Join(l.artCache, hashStr+ext)
+// Scan brings the library up to date with the music folder. Tags are only
+// read for files that are new or whose size or modification time changed;
+// files that disappeared are dropped from the index. progress, if non-nil,
+// is called as the scan advances. The scan stops early if ctx is done or
+// the library is closed; files read up to then stay in the index.
+func (l *Library) Scan(ctx context.Context, progress func(ScanProgress)) (*ScanResult, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(l.ctx, cancel)()
+
+	if progress == nil {
+		progress = func(ScanProgress) {}
+	}
 
-		// Save album art to cache if it doesn't exist
-		if _, err := os.Stat(artPath); os.IsNotExist(err) {
-			if err := os.WriteFile(artPath, picture.Data, 0644); err == nil {
-				// Successfully saved
-			} else {
-				artPath = "" // Clear on error
-			}
-		}
+	started := time.Now()
+	l.resetSidecars()
+
+	records, err := l.db.GetLibraryFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load library index: %w", err)
 	}
 
-	return
-}
+	indexed := make(map[string]*database.LibraryFile, len(records))
+	for _, record := range records {
+		if l.localFile(record) != nil {
+			indexed[record.Path] = record
+		}
+	}
 
-func (l *Library) Scan() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	type candidate struct {
+		path string
+		info fs.FileInfo
+	}
 
-	// Clear existing files
-	l.files = make(map[string][]*LocalFile)
+	var unchanged []*database.LibraryFile
+	var changed []candidate
+	seen := make(map[string]bool)
+	playlists := make(map[string]*Playlist)
+	cues := make(map[string]*cueFile)
+	var unreadable []string // Directories whose indexed files are kept as they are
 
-	// Walk through the directory
-	err := filepath.WalkDir(l.rootPath, func(path string, d fs.DirEntry, err error) error {
+	// Walk through the directory, only stat-ing files
+	err = filepath.WalkDir(l.rootPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return err
+			// Only an unreadable root fails the scan; anything below it is
+			// skipped so the rest of the library still loads
+			if path == l.rootPath {
+				return err
+			}
+			log.Printf("Skipping %s: %v", path, err)
+			if d != nil && d.IsDir() {
+				unreadable = append(unreadable, path)
+				return fs.SkipDir
+			}
+			return nil
 		}
 
 		// Skip directories
@@ -162,49 +360,186 @@ func (l *Library) Scan() error {
 			return nil
 		}
 
+		// Symlinks may only point at files inside the library, and that
+		// goes for playlists and CUE sheets too
+		if d.Type()&fs.ModeSymlink != 0 && !l.contains(path) {
+			log.Printf("Skipping %s: links outside the music library", path)
+			return nil
+		}
+
+		// Playlists are cheap to find and read when played, so they aren't
+		// kept in the persistent index
+		if playlist := l.newPlaylist(path); playlist != nil {
+			playlists[path] = playlist
+			return nil
+		}
+		if isCueSheet(path) {
+			for audioPath, cue := range loadCueSheet(path) {
+				cues[audioPath] = cue
+			}
+			return nil
+		}
+
 		// Check if file has supported extension
 		ext := strings.ToLower(filepath.Ext(d.Name()))
 		if !supportedExtensions[ext] {
 			return nil
 		}
 
-		// Get relative path from root
-		relPath, err := filepath.Rel(l.rootPath, path)
+		info, err := d.Info()
 		if err != nil {
-			return err
-		}
-
-		// Get folder name (use "root" if file is in root directory)
-		folder := filepath.Dir(relPath)
-		if folder == "." {
-			folder = "root"
+			log.Printf("Skipping %s: %v", path, err)
+			return nil
 		}
 
-		// Extract metadata and album art
-		title, artist, album, artPath := l.extractMetadata(path)
-
-		// Create local file entry
-		file := &LocalFile{
-			Name:     d.Name(),
-			Path:     path,
-			Folder:   folder,
-			AlbumArt: artPath,
-			Title:    title,
-			Artist:   artist,
-			Album:    album,
+		seen[path] = true
+		if record, ok := indexed[path]; ok && l.indexUpToDate(record, info) {
+			unchanged = append(unchanged, record)
+			return nil
 		}
 
-		// Add to files map
-		l.files[folder] = append(l.files[folder], file)
-
+		changed = append(changed, candidate{path: path, info: info})
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to scan music folder: %w", err)
+		return nil, fmt.Errorf("failed to scan music folder: %w", err)
 	}
 
-	return nil
+	result := &ScanResult{Total: len(seen)}
+	state := ScanProgress{Total: len(seen), Changed: len(changed)}
+	progress(state)
+
+	current := unchanged
+	batch := make([]*database.LibraryFile, 0, indexBatchSize)
+	for _, c := range changed {
+		record := l.readRecord(ctx, c.path, c.info)
+		if ctx.Err() != nil {
+			// The record may be missing its duration, so leave it out
+			break
+		}
+
+		if _, ok := indexed[c.path]; ok {
+			result.Updated++
+		} else {
+			result.Added++
+		}
+
+		current = append(current, record)
+		batch = append(batch, record)
+		if len(batch) == indexBatchSize {
+			if err := l.db.SaveLibraryFiles(batch); err != nil {
+				return nil, fmt.Errorf("failed to update library index: %w", err)
+			}
+			batch = batch[:0]
+		}
+
+		state.Processed++
+		progress(state)
+	}
+
+	if len(batch) > 0 {
+		if err := l.db.SaveLibraryFiles(batch); err != nil {
+			return nil, fmt.Errorf("failed to update library index: %w", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("scan stopped: %w", err)
+	}
+
+	var removed []string
+	for path, record := range indexed {
+		switch {
+		case seen[path]:
+		case underAny(path, unreadable):
+			current = append(current, record)
+		default:
+			removed = append(removed, path)
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := l.db.DeleteLibraryFiles(removed); err != nil {
+			return nil, fmt.Errorf("failed to prune library index: %w", err)
+		}
+	}
+	result.Removed = len(removed)
+
+	files := make(map[string][]*LocalFile)
+	for _, record := range current {
+		if file := l.localFile(record); file != nil {
+			files[file.Folder] = append(files[file.Folder], expandCue(file, cues)...)
+		}
+	}
+
+	l.mu.Lock()
+	l.files = files
+	l.byPath = indexPaths(files)
+	l.playlists = playlists
+	l.cues = cues
+	l.index = nil
+	l.mu.Unlock()
+
+	if removed, freed, err := l.art.Collect(); err != nil {
+		log.Printf("Failed to clean up album art cache: %v", err)
+	} else if removed > 0 {
+		log.Printf("Removed %d unused album art files (%d KiB)", removed, freed>>10)
+	}
+
+	result.Elapsed = time.Since(started)
+	return result, nil
+}
+
+// underAny reports whether path lies inside one of dirs.
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readRecord reads a file's tags and album art into a new index record.
+// ctx bounds the ffprobe run used for durations containers don't give.
+func (l *Library) readRecord(ctx context.Context, path string, info fs.FileInfo) *database.LibraryFile {
+	// Extract metadata and album art
+	tags, artPath := l.extractMetadata(path)
+
+	return &database.LibraryFile{
+		Path:        path,
+		Size:        info.Size(),
+		ModTime:     info.ModTime().UnixNano(),
+		Title:       tags.Title,
+		Artist:      tags.Artist,
+		Album:       tags.Album,
+		AlbumArtist: tags.AlbumArtist,
+		Genre:       tags.Genre,
+		Year:        tags.Year,
+		TrackNumber: tags.TrackNumber,
+		DiscNumber:  tags.DiscNumber,
+		AlbumArt:    artPath,
+		Duration:    probeDuration(ctx, path),
+		ScanVersion: scanVersion,
+	}
+}
+
+// indexUpToDate reports whether an index record still describes the file
+// on disk, including any album art it points at.
+func (l *Library) indexUpToDate(record *database.LibraryFile, info fs.FileInfo) bool {
+	if record.Size != info.Size() || record.ModTime != info.ModTime().UnixNano() || record.ScanVersion < scanVersion {
+		return false
+	}
+
+	if record.AlbumArt != "" {
+		if _, err := os.Stat(record.AlbumArt); err != nil {
+			return false
+		}
+		return true
+	}
+
+	// Pick up cover images added next to files that had no art
+	return l.sidecarArt(filepath.Dir(record.Path)) == ""
 }
 
 func (l *Library) GetFolders() []string {
@@ -233,6 +568,99 @@ func (l *Library) GetFiles(folder string) []*LocalFile {
 	return []*LocalFile{}
 }
 
+// Orders accepted by FolderFiles.
+const (
+	OrderName  = "name"  // By path, so subfolders stay together
+	OrderTrack = "track" // By folder, then disc and track number
+	OrderDate  = "date"  // Oldest modification time first
+)
+
+// FolderFiles returns the files in a folder, and with recursive set the
+// files in all of its subfolders too, sorted by order. The "root" folder
+// with recursive set returns the whole library.
+func (l *Library) FolderFiles(folder string, recursive bool, order string) ([]*LocalFile, error) {
+	switch order {
+	case OrderName, OrderTrack, OrderDate:
+	default:
+		return nil, fmt.Errorf("unknown sort order: %s", order)
+	}
+
+	l.mu.RLock()
+	files := append([]*LocalFile(nil), l.files[folder]...)
+	found := len(files) > 0
+	if recursive {
+		prefix := folder + string(filepath.Separator)
+		for key, folderFiles := range l.files {
+			if folder == "root" || strings.HasPrefix(key, prefix) {
+				if key != folder {
+					files = append(files, folderFiles...)
+				}
+				found = true
+			}
+		}
+	}
+	l.mu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("folder not found: %s", folder)
+	}
+
+	switch order {
+	case OrderName:
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].Path != files[j].Path {
+				return files[i].Path < files[j].Path
+			}
+			return files[i].Start < files[j].Start
+		})
+	case OrderTrack:
+		SortByTrackNumber(files)
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Folder < files[j].Folder })
+	case OrderDate:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].modTime < files[j].modTime })
+	}
+
+	return files, nil
+}
+
+// FileByPath returns the library entry for a file, or nil if the file isn't
+// in the library. For a file split by a CUE sheet, it returns the track
+// starting at start.
+func (l *Library) FileByPath(path string, start time.Duration) *LocalFile {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := l.byPath[path]
+	for _, file := range entries {
+		if file.Start == start {
+			return file
+		}
+	}
+	if len(entries) > 0 {
+		return entries[0]
+	}
+	return nil
+}
+
+func indexPaths(files map[string][]*LocalFile) map[string][]*LocalFile {
+	byPath := make(map[string][]*LocalFile)
+	for _, folderFiles := range files {
+		for _, file := range folderFiles {
+			byPath[file.Path] = append(byPath[file.Path], file)
+		}
+	}
+	return byPath
+}
+
+// HasFolder reports whether the library contains a folder.
+func (l *Library) HasFolder(folder string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, exists := l.files[folder]
+	return exists
+}
+
 func (l *Library) GetAllFiles() []*LocalFile {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -246,55 +674,103 @@ func (l *Library) GetAllFiles() []*LocalFile {
 }
 
 func (l *Library) SearchByName(query string) []*LocalFile {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	results := l.Search(query)
+	files := make([]*LocalFile, len(results))
+	for i, result := range results {
+		files[i] = result.File
+	}
+	return files
+}
 
-	query = strings.ToLower(query)
-	results := make([]*LocalFile, 0)
+// maxChoices caps how many candidates an ambiguous lookup offers.
+const maxChoices = 5
 
-	for _, files := range l.files {
-		for _, file := range files {
-			if strings.Contains(strings.ToLower(file.Name), query) {
-				results = append(results, file)
-			}
-		}
-	}
+// AmbiguousMatchError is returned when a lookup matches several files
+// equally well. Matches holds the best candidates, most relevant first.
+type AmbiguousMatchError struct {
+	Query   string
+	Matches []*LocalFile
+}
 
-	return results
+func (e *AmbiguousMatchError) Error() string {
+	return fmt.Sprintf("%d files match %q", len(e.Matches), e.Query)
 }
 
+// GetFileByFolderAndName finds a file in a folder. An exact filename wins;
+// otherwise the files are ranked like Search and the best match is used if
+// it clearly beats the rest. Close calls return an *AmbiguousMatchError.
 func (l *Library) GetFileByFolderAndName(folder, name string) (*LocalFile, error) {
+	file, exists := l.fileByName(folder, name)
+	if !exists {
+		return nil, fmt.Errorf("folder not found: %s", folder)
+	}
+	if file != nil {
+		return file, nil
+	}
+
+	file, err := pickResult(l.SearchFolder(folder, name), name)
+	if err != nil && !isAmbiguous(err) {
+		return nil, fmt.Errorf("file not found: %s in folder %s", name, folder)
+	}
+	return file, err
+}
+
+// fileByName returns the file whose name matches exactly, or failing that
+// case-insensitively, and whether the folder exists.
+func (l *Library) fileByName(folder, name string) (*LocalFile, bool) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
 	files, exists := l.files[folder]
 	if !exists {
-		return nil, fmt.Errorf("folder not found: %s", folder)
+		return nil, false
 	}
 
 	// Try exact match first
 	for _, file := range files {
 		if file.Name == name {
-			return file, nil
+			return file, true
 		}
 	}
 
 	// Try case-insensitive match
-	nameLower := strings.ToLower(name)
 	for _, file := range files {
-		if strings.ToLower(file.Name) == nameLower {
-			return file, nil
+		if strings.EqualFold(file.Name, name) {
+			return file, true
 		}
 	}
 
-	// Try partial match
-	for _, file := range files {
-		if strings.Contains(strings.ToLower(file.Name), nameLower) {
-			return file, nil
-		}
+	return nil, true
+}
+
+// FindFile finds a file anywhere in the library, like
+// GetFileByFolderAndName does within a folder.
+func (l *Library) FindFile(query string) (*LocalFile, error) {
+	file, err := pickResult(l.Search(query), query)
+	if err != nil && !isAmbiguous(err) {
+		return nil, fmt.Errorf("file not found: %s", query)
 	}
+	return file, err
+}
+
+func pickResult(results []SearchResult, query string) (*LocalFile, error) {
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no match")
+	}
+	if Unambiguous(results) {
+		return results[0].File, nil
+	}
+
+	matches := make([]*LocalFile, 0, maxChoices)
+	for _, result := range results[:min(len(results), maxChoices)] {
+		matches = append(matches, result.File)
+	}
+	return nil, &AmbiguousMatchError{Query: query, Matches: matches}
+}
 
-	return nil, fmt.Errorf("file not found: %s in folder %s", name, folder)
+func isAmbiguous(err error) bool {
+	var ambiguous *AmbiguousMatchError
+	return errors.As(err, &ambiguous)
 }
 
 func (l *Library) GetTotalFiles() int {
@@ -0,0 +1,104 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideLibrary is returned for local paths that don't resolve to a
+// file inside a configured library root.
+var ErrOutsideLibrary = errors.New("path is outside the music library")
+
+// IsLocalPath reports whether input names a file on disk rather than a URL
+// or search query: an absolute path or a file:// URL.
+func IsLocalPath(input string) bool {
+	return filepath.IsAbs(input) || strings.HasPrefix(strings.ToLower(input), "file:")
+}
+
+// resolveWithin follows symlinks in path and returns the real file it
+// points at, provided that file sits inside one of roots. Anything else,
+// including a missing file, is ErrOutsideLibrary.
+func resolveWithin(path string, roots []string) (string, error) {
+	if strings.HasPrefix(strings.ToLower(path), "file:") {
+		u, err := url.Parse(path)
+		if err != nil || (u.Host != "" && u.Host != "localhost") {
+			return "", fmt.Errorf("%w: %s", ErrOutsideLibrary, path)
+		}
+		path = filepath.FromSlash(u.Path)
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideLibrary, path)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrOutsideLibrary, path)
+	}
+
+	for _, root := range roots {
+		if _, ok := withinRoot(root, resolved); ok {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrOutsideLibrary, path)
+}
+
+// withinRoot reports whether the resolved path lies below root, and where
+// relative to it. The root is resolved too, so a library configured through
+// a symlink or as a relative path still matches.
+func withinRoot(root, resolved string) (string, bool) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", false
+	}
+	realRoot, err = filepath.Abs(realRoot)
+	if err != nil {
+		return "", false
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(realRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// contains reports whether path, after following symlinks, is still inside
+// the library root. The scanner uses it to skip links that point elsewhere.
+func (l *Library) contains(path string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	_, ok := withinRoot(l.rootPath, resolved)
+	return ok
+}
@@ -22,15 +22,12 @@
 package music
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jonas747/dca"
@@ -43,105 +40,69 @@ type Track struct {
 	Thumbnail string
 	Requester string
 	IsLocal   bool
+
+	// IsDirect tracks are audio files on the web, such as Discord
+	// attachments, that are downloaded directly instead of through yt-dlp.
+	// CachePath is a local copy, played instead of URL once it exists.
+	IsDirect  bool
+	CachePath string
+
+	// IsLive tracks are radio and HLS streams, which have no duration and
+	// play until skipped or stopped.
+	IsLive bool
+
+	// SavePosition, if set, is told where playback stopped once the track
+	// ends, is skipped or playback stops, so it can resume there later.
+	// finished is true if it played to the end.
+	SavePosition func(position time.Duration, finished bool)
+
+	// Start and End play only part of a local file, for CUE sheet tracks.
+	// End is zero to play to the end of the file.
+	Start time.Duration
+	End   time.Duration
+
+	resolver Resolver // Set by Resolvers.Resolve
 }
 
 type Player struct {
-	guildID       string
-	voiceConn     *discordgo.VoiceConnection
-	encoding      *dca.EncodeSession
-	streaming     *dca.StreamingSession
-	queue         []*Track
-	nowPlaying    *Track
-	volume        int
-	mu            sync.RWMutex
-	stopChan      chan bool
-	isPlaying     bool
-	isPaused      bool
+	guildID     string
+	resolvers   *Resolvers
+	voiceConn   *discordgo.VoiceConnection
+	encoding    *dca.EncodeSession
+	streaming   *dca.StreamingSession
+	queue       []*Track
+	nowPlaying  *Track
+	volume      int
+	mu          sync.RWMutex
+	stopChan    chan bool
+	skipChan    chan struct{}
+	streamTitle string // Latest StreamTitle of a live track
+	isPlaying   bool
+	isPaused    bool
+
+	// OnSpeaking, if set before Connect, is called with the ID of anyone
+	// who starts speaking in the player's voice channel.
+	OnSpeaking func(userID string)
 }
 
-func NewPlayer(guildID string) *Player {
+func NewPlayer(guildID string, resolvers *Resolvers) *Player {
 	return &Player{
 		guildID:   guildID,
+		resolvers: resolvers,
 		queue:     make([]*Track, 0),
 		volume:    50,
-		stopChan:  make(chan bool),
+		stopChan:  make(chan bool, 1),
+		skipChan:  make(chan struct{}, 1),
 		isPlaying: false,
 		isPaused:  false,
 	}
 }
 
-type VideoInfo struct {
-	Title     string `json:"title"`
-	URL       string `json:"url"`
-	Duration  int    `json:"duration"`
-	Thumbnail string `json:"thumbnail"`
-}
+var errStopped = errors.New("playback stopped")
 
-func ExtractInfo(url string) (*VideoInfo, error) {
-	// Check if it's a local file path
-	if isLocalFile(url) {
-		return extractLocalFileInfo(url)
-	}
-
-	args := []string{
-		"--dump-json",
-		"--no-playlist",
-		"--format", "bestaudio",
-	}
-
-	// Add API keys if available (helps avoid rate limiting)
-	if youtubeKey := os.Getenv("YOUTUBE_API_KEY"); youtubeKey != "" {
-		args = append(args, "--username", "oauth2", "--password", "")
-	}
-
-	if soundcloudAuth := os.Getenv("SOUNDCLOUD_AUTH_TOKEN"); soundcloudAuth != "" {
-		args = append(args, "--add-header", "Authorization:OAuth "+soundcloudAuth)
-	}
-
-	args = append(args, url)
-
-	cmd := exec.Command("yt-dlp", args...)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract info: %w", err)
-	}
-
-	var info VideoInfo
-	if err := json.Unmarshal(output, &info); err != nil {
-		return nil, fmt.Errorf("failed to parse video info: %w", err)
-	}
-
-	return &info, nil
-}
-
-func isLocalFile(path string) bool {
-	// Check if it's an absolute path
-	if filepath.IsAbs(path) {
-		if _, err := os.Stat(path); err == nil {
-			return true
-		}
-	}
-	return false
-}
-
-func extractLocalFileInfo(path string) (*VideoInfo, error) {
-	// Check if file exists
-	if _, err := os.Stat(path); err != nil {
-		return nil, fmt.Errorf("file not found: %w", err)
-	}
-
-	// Get file name without extension for title
-	fileName := filepath.Base(path)
-	title := strings.TrimSuffix(fileName, filepath.Ext(fileName))
-
-	return &VideoInfo{
-		Title:     title,
-		URL:       path,
-		Duration:  0, // Duration extraction can be added later
-		Thumbnail: "",
-	}, nil
-}
+// ErrNotOwner is returned by the IfOwned queue edits when the track was
+// requested by someone else.
+var ErrNotOwner = errors.New("that track was requested by someone else")
 
 func (p *Player) Connect(s *discordgo.Session, channelID string) error {
 	p.mu.Lock()
@@ -156,6 +117,12 @@ func (p *Player) Connect(s *discordgo.Session, channelID string) error {
 		return fmt.Errorf("failed to join voice channel: %w", err)
 	}
 
+	vc.AddHandler(func(_ *discordgo.VoiceConnection, vs *discordgo.VoiceSpeakingUpdate) {
+		if vs.Speaking && p.OnSpeaking != nil {
+			p.OnSpeaking(vs.UserID)
+		}
+	})
+
 	p.voiceConn = vc
 	return nil
 }
@@ -203,6 +170,24 @@ func (p *Player) RemoveTrack(position int) error {
 	return nil
 }
 
+// RemoveTrackIfOwned removes the track at position only if userID requested
+// it. Checking and removing under one lock keeps a skip or removal from
+// shifting someone else's track into place in between.
+func (p *Player) RemoveTrackIfOwned(position int, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if position < 0 || position >= len(p.queue) {
+		return errors.New("invalid position")
+	}
+	if p.queue[position].Requester != userID {
+		return ErrNotOwner
+	}
+
+	p.queue = append(p.queue[:position], p.queue[position+1:]...)
+	return nil
+}
+
 func (p *Player) MoveToTop(position int) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -218,6 +203,55 @@ func (p *Player) MoveToTop(position int) error {
 	return nil
 }
 
+// RemoveUserTracks removes every queued track requested by userID and
+// returns how many were removed.
+func (p *Player) RemoveUserTracks(userID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kept := make([]*Track, 0, len(p.queue))
+	for _, track := range p.queue {
+		if track.Requester != userID {
+			kept = append(kept, track)
+		}
+	}
+
+	removed := len(p.queue) - len(kept)
+	p.queue = kept
+	return removed
+}
+
+func (p *Player) MoveTrack(from, to int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.moveTrack(from, to)
+}
+
+// MoveTrackIfOwned moves a track only if userID requested it, checking and
+// moving under one lock like RemoveTrackIfOwned.
+func (p *Player) MoveTrackIfOwned(from, to int, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if from >= 0 && from < len(p.queue) && p.queue[from].Requester != userID {
+		return ErrNotOwner
+	}
+	return p.moveTrack(from, to)
+}
+
+func (p *Player) moveTrack(from, to int) error {
+	if from < 0 || from >= len(p.queue) || to < 0 || to >= len(p.queue) {
+		return errors.New("invalid position")
+	}
+
+	track := p.queue[from]
+	p.queue = append(p.queue[:from], p.queue[from+1:]...)
+	p.queue = append(p.queue[:to], append([]*Track{track}, p.queue[to:]...)...)
+
+	return nil
+}
+
 func (p *Player) ClearQueue() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -242,6 +276,12 @@ func (p *Player) Play() error {
 		return errors.New("not connected to voice channel")
 	}
 
+	// Drop a stop that arrived after the last loop had already finished
+	select {
+	case <-p.stopChan:
+	default:
+	}
+
 	p.isPlaying = true
 	p.mu.Unlock()
 
@@ -262,20 +302,16 @@ func (p *Player) playLoop() {
 		track := p.queue[0]
 		p.queue = p.queue[1:]
 		p.nowPlaying = track
+		p.streamTitle = ""
 		p.mu.Unlock()
 
-		if err := p.playTrack(track); err != nil {
-			fmt.Printf("Error playing track: %v\n", err)
-		}
-
-		select {
-		case <-p.stopChan:
-			p.mu.Lock()
-			p.isPlaying = false
-			p.nowPlaying = nil
-			p.mu.Unlock()
+		err := p.playTrack(track)
+		if errors.Is(err, errStopped) {
+			// Stop has already reset the player
 			return
-		default:
+		}
+		if err != nil {
+			fmt.Printf("Error playing track: %v\n", err)
 		}
 	}
 }
@@ -287,125 +323,135 @@ func (p *Player) playTrack(track *Track) error {
 	options.Application = "audio"
 	options.Volume = p.volume
 
-	var cmd *exec.Cmd
-	var stdout io.ReadCloser
-	var err error
-
-	// Handle local files differently
-	if track.IsLocal {
-		// Use ffmpeg directly for local files
-		encodeSession, err := dca.EncodeFile(track.URL, options)
-		if err != nil {
-			return fmt.Errorf("failed to encode local file: %w", err)
-		}
-		defer encodeSession.Cleanup()
-
-		p.mu.Lock()
-		p.encoding = encodeSession
-		done := make(chan error)
-		streamSession := dca.NewStream(encodeSession, p.voiceConn, done)
-		p.streaming = streamSession
-		p.mu.Unlock()
-
-		select {
-		case err := <-done:
-			if err != nil && err != io.EOF {
-				return fmt.Errorf("streaming error: %w", err)
-			}
-		case <-p.stopChan:
-			p.mu.Lock()
-			if p.streaming != nil {
-				p.streaming.SetPaused(true)
-			}
-			if p.encoding != nil {
-				p.encoding.Cleanup()
-			}
-			p.mu.Unlock()
-			return nil
-		}
-
-		return nil
-	}
-
-	// Handle online URLs with yt-dlp
-	args := []string{
-		"--format", "bestaudio",
-		"--output", "-",
-		"--no-playlist",
-	}
-
-	// Add API keys if available (helps avoid rate limiting)
-	if youtubeKey := os.Getenv("YOUTUBE_API_KEY"); youtubeKey != "" {
-		args = append(args, "--username", "oauth2", "--password", "")
+	if track.Start > 0 || track.End > 0 {
+		options.AudioFilter = trimFilter(track.Start, track.End)
 	}
 
-	if soundcloudAuth := os.Getenv("SOUNDCLOUD_AUTH_TOKEN"); soundcloudAuth != "" {
-		args = append(args, "--add-header", "Authorization:OAuth "+soundcloudAuth)
+	// A skip requested while the previous track was ending is stale
+	select {
+	case <-p.skipChan:
+	default:
 	}
 
-	args = append(args, track.URL)
-
-	cmd = exec.Command("yt-dlp", args...)
+	// Cancelled once the track is over, which stops downloads and helper
+	// processes behind the audio
+	ctx, cancel := context.WithCancel(WithGuild(context.Background(), p.guildID))
+	defer cancel()
 
-	stdout, err = cmd.StdoutPipe()
+	audio, err := p.resolvers.Stream(ctx, track)
 	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+		return err
 	}
+	defer audio.Close()
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start yt-dlp: %w", err)
+	if source, ok := audio.(titleSource); ok {
+		source.OnTitle(p.setStreamTitle)
 	}
 
-	encodeSession, err := dca.EncodeMem(stdout, options)
+	var encodeSession *dca.EncodeSession
+	if input, ok := audio.(*ffmpegInput); ok {
+		encodeSession, err = dca.EncodeFile(input.input, options)
+	} else {
+		encodeSession, err = dca.EncodeMem(audio, options)
+	}
 	if err != nil {
-		cmd.Process.Kill()
 		return fmt.Errorf("failed to encode audio: %w", err)
 	}
+
+	return p.stream(track, encodeSession)
+}
+
+// stream sends an encoded track to the voice connection until it ends, is
+// skipped, or playback stops. Live streams only ever end the latter ways.
+// It returns errStopped when playback was stopped.
+func (p *Player) stream(track *Track, encodeSession *dca.EncodeSession) error {
 	defer encodeSession.Cleanup()
 
 	p.mu.Lock()
 	p.encoding = encodeSession
-	done := make(chan error)
+	done := make(chan error, 1)
 	streamSession := dca.NewStream(encodeSession, p.voiceConn, done)
 	p.streaming = streamSession
 	p.mu.Unlock()
 
+	var err error
+	finished := false
 	select {
-	case err := <-done:
+	case err = <-done:
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("streaming error: %w", err)
+			err = fmt.Errorf("streaming error: %w", err)
+		} else {
+			err = nil
+			finished = true
 		}
+	case <-p.skipChan:
+		p.halt()
 	case <-p.stopChan:
-		p.mu.Lock()
-		if p.streaming != nil {
-			p.streaming.SetPaused(true)
-		}
-		if p.encoding != nil {
-			p.encoding.Cleanup()
-		}
-		p.mu.Unlock()
-		return nil
+		p.halt()
+		err = errStopped
 	}
 
-	cmd.Wait()
-	return nil
+	if track.SavePosition != nil {
+		track.SavePosition(track.Start+streamSession.PlaybackPosition(), finished)
+	}
+	return err
 }
 
-func (p *Player) Skip() error {
+// halt stops sending and encoding the current track.
+func (p *Player) halt() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if !p.isPlaying {
-		return errors.New("nothing is playing")
-	}
-
 	if p.streaming != nil {
 		p.streaming.SetPaused(true)
 	}
-
 	if p.encoding != nil {
 		p.encoding.Cleanup()
 	}
+}
+
+func (p *Player) setStreamTitle(title string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.streamTitle = title
+}
+
+// StreamTitle returns the song a live stream last announced, or "" if it
+// hasn't announced one.
+func (p *Player) StreamTitle() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.streamTitle
+}
+
+// trimFilter returns an ffmpeg audio filter that keeps only the audio
+// between start and end. dca's StartTime only takes whole seconds, which
+// would clip or overlap the neighbouring CUE tracks.
+func trimFilter(start, end time.Duration) string {
+	filter := fmt.Sprintf("atrim=start=%.3f", start.Seconds())
+	if end > 0 {
+		filter += fmt.Sprintf(":end=%.3f", end.Seconds())
+	}
+	return filter + ",asetpts=PTS-STARTPTS"
+}
+
+func (p *Player) Skip() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.isPlaying {
+		return errors.New("nothing is playing")
+	}
+
+	// Pausing the stream alone would leave playTrack waiting for a track
+	// that never ends, so it is told to move on
+	select {
+	case p.skipChan <- struct{}{}:
+	default:
+	}
+	p.isPaused = false
 
 	return nil
 }
@@ -430,6 +476,7 @@ func (p *Player) Stop() {
 	}
 
 	p.isPlaying = false
+	p.isPaused = false
 	p.nowPlaying = nil
 }
 
@@ -0,0 +1,226 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Playlist is an .m3u or .pls file found in the music folder.
+type Playlist struct {
+	Name   string // File name without extension
+	Path   string
+	Folder string
+	Root   string // Name of the library root the playlist belongs to
+}
+
+var playlistExtensions = map[string]bool{
+	".m3u":  true,
+	".m3u8": true,
+	".pls":  true,
+}
+
+// newPlaylist builds a playlist entry for a file, or returns nil if the
+// file isn't a playlist inside this library's root.
+func (l *Library) newPlaylist(path string) *Playlist {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !playlistExtensions[ext] {
+		return nil
+	}
+
+	relPath, err := filepath.Rel(l.rootPath, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	folder := filepath.Dir(relPath)
+	if folder == "." {
+		folder = "root"
+	}
+
+	return &Playlist{
+		Name:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Path:   path,
+		Folder: folder,
+		Root:   l.name,
+	}
+}
+
+// readPlaylist returns the entries of an M3U or PLS playlist in order, as
+// written in the file.
+func readPlaylist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pls := strings.EqualFold(filepath.Ext(path), ".pls")
+
+	type plsEntry struct {
+		index int
+		value string
+	}
+	var entries []string
+	var plsEntries []plsEntry
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\uFEFF"))
+		if line == "" {
+			continue
+		}
+
+		if !pls {
+			// #EXTM3U, #EXTINF and other directives
+			if !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+			continue
+		}
+
+		// PLS entries look like File3=path; the number sets the order
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || len(key) <= 4 || !strings.EqualFold(key[:4], "file") {
+			continue
+		}
+		index, err := strconv.Atoi(key[4:])
+		if err != nil {
+			continue
+		}
+		plsEntries = append(plsEntries, plsEntry{index: index, value: strings.TrimSpace(value)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if pls {
+		sort.SliceStable(plsEntries, func(i, j int) bool { return plsEntries[i].index < plsEntries[j].index })
+		for _, entry := range plsEntries {
+			entries = append(entries, entry.value)
+		}
+	}
+
+	return entries, nil
+}
+
+// resolveEntry turns a playlist entry into an absolute path. Relative paths
+// are resolved against the playlist's directory. It returns "" for remote
+// URLs, which the local library can't play.
+func resolveEntry(playlistDir, entry string) string {
+	if strings.HasPrefix(strings.ToLower(entry), "file://") {
+		u, err := url.Parse(entry)
+		if err != nil {
+			return ""
+		}
+		return filepath.Clean(u.Path)
+	}
+	if strings.Contains(entry, "://") {
+		return ""
+	}
+
+	// Playlists made on Windows use backslashes
+	if filepath.Separator == '/' {
+		entry = strings.ReplaceAll(entry, `\`, "/")
+	}
+
+	if !filepath.IsAbs(entry) {
+		entry = filepath.Join(playlistDir, entry)
+	}
+	return filepath.Clean(entry)
+}
+
+// ResolvePlaylist reads a playlist and matches its entries against the
+// library. Entries that don't point at an indexed file, including files
+// outside the library, are returned in missing as written.
+func (l *Library) ResolvePlaylist(playlist *Playlist) (files []*LocalFile, missing []string, err error) {
+	entries, err := readPlaylist(playlist.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	dir := filepath.Dir(playlist.Path)
+
+	l.mu.RLock()
+	for _, entry := range entries {
+		// A file split by a CUE sheet stands for all of its tracks
+		if matches, ok := l.byPath[resolveEntry(dir, entry)]; ok {
+			files = append(files, matches...)
+		} else {
+			missing = append(missing, entry)
+		}
+	}
+	l.mu.RUnlock()
+
+	return files, missing, nil
+}
+
+// Playlists returns every playlist in the library, sorted by name.
+func (l *Library) Playlists() []*Playlist {
+	l.mu.RLock()
+	playlists := make([]*Playlist, 0, len(l.playlists))
+	for _, playlist := range l.playlists {
+		playlists = append(playlists, playlist)
+	}
+	l.mu.RUnlock()
+
+	sortPlaylists(playlists)
+	return playlists
+}
+
+// FindPlaylists returns the playlists with the given name, falling back to
+// playlists whose name contains it.
+func (l *Library) FindPlaylists(name string) []*Playlist {
+	var exact, partial []*Playlist
+	key := normalizeKey(name)
+	for _, playlist := range l.Playlists() {
+		playlistName := normalizeKey(playlist.Name)
+		switch {
+		case playlistName == key:
+			exact = append(exact, playlist)
+		case strings.Contains(playlistName, key):
+			partial = append(partial, playlist)
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+func sortPlaylists(playlists []*Playlist) {
+	sort.Slice(playlists, func(i, j int) bool {
+		a, b := normalizeKey(playlists[i].Name), normalizeKey(playlists[j].Name)
+		if a != b {
+			return a < b
+		}
+		return playlists[i].Path < playlists[j].Path
+	})
+}
@@ -0,0 +1,319 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	feedTimeout = 20 * time.Second
+
+	// maxFeedSize caps how much of a feed is read; feeds with years of
+	// episodes and full show notes can run to several megabytes.
+	maxFeedSize = 20 << 20
+)
+
+var ErrNotFeed = errors.New("not an RSS or Atom feed")
+
+// Feed is a podcast feed, with its episodes newest first.
+type Feed struct {
+	Title    string
+	Image    string
+	Episodes []*Episode
+}
+
+// Episode is a feed entry with an audio enclosure.
+type Episode struct {
+	ID        string // The entry's guid, or its enclosure URL if it has none
+	Title     string
+	URL       string
+	Image     string
+	Published time.Time
+	Duration  int // Seconds, 0 if the feed doesn't say
+}
+
+// Track returns a track playing the episode from start.
+func (e *Episode) Track(start time.Duration, requester string) *Track {
+	return &Track{
+		Title:     e.Title,
+		URL:       e.URL,
+		Duration:  e.Duration,
+		Thumbnail: e.Image,
+		Requester: requester,
+		IsDirect:  true,
+		Start:     start,
+	}
+}
+
+// FetchFeed downloads and parses an RSS or Atom podcast feed from a host
+// hosts allows, giving up when ctx is done or after feedTimeout. Entries
+// without an audio enclosure are left out.
+func FetchFeed(ctx context.Context, hosts *HostFilter, url string) (*Feed, error) {
+	ctx, cancel := context.WithTimeout(ctx, feedTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := hosts.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed: %s", resp.Status)
+	}
+
+	feed, err := parseFeed(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if feed.Title == "" {
+		feed.Title = url
+	}
+	for _, episode := range feed.Episodes {
+		if episode.Image == "" {
+			episode.Image = feed.Image
+		}
+	}
+	sort.SliceStable(feed.Episodes, func(i, j int) bool {
+		return feed.Episodes[i].Published.After(feed.Episodes[j].Published)
+	})
+
+	return feed, nil
+}
+
+type rssFeed struct {
+	Channel struct {
+		Title  string      `xml:"title"`
+		Images []feedImage `xml:"image"`
+		Items  []struct {
+			Title     string `xml:"title"`
+			GUID      string `xml:"guid"`
+			PubDate   string `xml:"pubDate"`
+			Duration  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+			Images []feedImage `xml:"image"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// feedImage is either RSS's <image><url>...</url></image> or iTunes'
+// <itunes:image href="..."/>; a namespace-less tag matches both.
+type feedImage struct {
+	URL  string `xml:"url"`
+	Href string `xml:"href,attr"`
+}
+
+// imageURL prefers the iTunes image, which podcast apps show too.
+func imageURL(images []feedImage) string {
+	url := ""
+	for _, image := range images {
+		if image.Href != "" {
+			return image.Href
+		}
+		url = firstNonEmpty(url, image.URL)
+	}
+	return url
+}
+
+type atomFeed struct {
+	Title   string `xml:"title"`
+	Logo    string `xml:"logo"`
+	Icon    string `xml:"icon"`
+	Entries []struct {
+		Title     string `xml:"title"`
+		ID        string `xml:"id"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Duration  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+		Links     []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+func parseFeed(r io.Reader) (*Feed, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.CharsetReader = latin1Reader
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, ErrNotFeed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotFeed, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "rss":
+			var rss rssFeed
+			if err := decoder.DecodeElement(&rss, &start); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNotFeed, err)
+			}
+			return rss.feed(), nil
+		case "feed":
+			var atom atomFeed
+			if err := decoder.DecodeElement(&atom, &start); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNotFeed, err)
+			}
+			return atom.feed(), nil
+		default:
+			return nil, ErrNotFeed
+		}
+	}
+}
+
+func (rss *rssFeed) feed() *Feed {
+	feed := &Feed{
+		Title: strings.TrimSpace(rss.Channel.Title),
+		Image: imageURL(rss.Channel.Images),
+	}
+
+	for _, item := range rss.Channel.Items {
+		if item.Enclosure.URL == "" || !isAudioEnclosure(item.Enclosure.Type) {
+			continue
+		}
+		feed.Episodes = append(feed.Episodes, &Episode{
+			ID:        firstNonEmpty(strings.TrimSpace(item.GUID), item.Enclosure.URL),
+			Title:     firstNonEmpty(strings.TrimSpace(item.Title), item.Enclosure.URL),
+			URL:       item.Enclosure.URL,
+			Image:     imageURL(item.Images),
+			Published: parseFeedTime(item.PubDate),
+			Duration:  parseFeedDuration(item.Duration),
+		})
+	}
+	return feed
+}
+
+func (atom *atomFeed) feed() *Feed {
+	feed := &Feed{
+		Title: strings.TrimSpace(atom.Title),
+		Image: firstNonEmpty(atom.Logo, atom.Icon),
+	}
+
+	for _, entry := range atom.Entries {
+		for _, link := range entry.Links {
+			if link.Rel != "enclosure" || link.Href == "" || !isAudioEnclosure(link.Type) {
+				continue
+			}
+			feed.Episodes = append(feed.Episodes, &Episode{
+				ID:        firstNonEmpty(strings.TrimSpace(entry.ID), link.Href),
+				Title:     firstNonEmpty(strings.TrimSpace(entry.Title), link.Href),
+				URL:       link.Href,
+				Published: parseFeedTime(firstNonEmpty(entry.Published, entry.Updated)),
+				Duration:  parseFeedDuration(entry.Duration),
+			})
+			break
+		}
+	}
+	return feed
+}
+
+// isAudioEnclosure accepts audio enclosures and ones without a type, which
+// are nearly always audio in podcast feeds.
+func isAudioEnclosure(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return contentType == "" || strings.HasPrefix(contentType, "audio/") || attachmentTypes[contentType]
+}
+
+// feedTimeLayouts covers RFC 822 dates as RSS feeds actually write them,
+// and Atom's RFC 3339.
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseFeedDuration reads itunes:duration, which is either seconds or
+// [[h:]m:]s.
+func parseFeedDuration(value string) int {
+	seconds := 0
+	for _, part := range strings.Split(strings.TrimSpace(value), ":") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+// latin1Reader decodes feeds that declare a Latin-1 or Windows-1252
+// encoding. Windows-1252's extra punctuation comes through as Latin-1,
+// which is close enough for titles.
+func latin1Reader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1", "windows-1252", "cp1252", "us-ascii":
+	default:
+		return nil, fmt.Errorf("unsupported feed encoding %q", charset)
+	}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return strings.NewReader(string(runes)), nil
+}
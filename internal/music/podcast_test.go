@@ -0,0 +1,253 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeedDuration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"", 0},
+		{"90", 90},
+		{" 45 ", 45},
+		{"01:30", 90},
+		{"1:02:03", 3723},
+		{"00:00:07", 7},
+		{"1.5", 0},
+		{"12 minutes", 0},
+		{"1::30", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseFeedDuration(tt.input); got != tt.want {
+			t.Errorf("parseFeedDuration(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseFeed(t *testing.T) {
+	type episode struct {
+		id, title, url, image string
+		published             time.Time
+		duration              int
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		wantErr   error
+		wantTitle string
+		wantImage string
+		episodes  []episode
+	}{
+		{
+			name: "rss",
+			body: `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+  <title> Miku Talk </title>
+  <image><url>https://example.com/rss.png</url></image>
+  <itunes:image href="https://example.com/itunes.png"/>
+  <item>
+    <title>Episode 2</title>
+    <guid>ep-2</guid>
+    <pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+    <itunes:duration>1:02:03</itunes:duration>
+    <enclosure url="https://example.com/2.mp3" type="audio/mpeg" length="1"/>
+    <itunes:image href="https://example.com/2.png"/>
+  </item>
+  <item>
+    <title>Video only</title>
+    <enclosure url="https://example.com/v.mkv" type="video/x-matroska"/>
+  </item>
+  <item>
+    <title>Show notes only</title>
+  </item>
+  <item>
+    <pubDate>Mon, 1 Jan 2024 10:00:00 GMT</pubDate>
+    <itunes:duration>95</itunes:duration>
+    <enclosure url="https://example.com/1.m4a"/>
+  </item>
+  <item>
+    <title>Video podcast</title>
+    <enclosure url="https://example.com/3.mp4" type="video/mp4"/>
+  </item>
+</channel>
+</rss>`,
+			wantTitle: "Miku Talk",
+			wantImage: "https://example.com/itunes.png",
+			episodes: []episode{
+				{"ep-2", "Episode 2", "https://example.com/2.mp3", "https://example.com/2.png", time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC), 3723},
+				{"https://example.com/1.m4a", "https://example.com/1.m4a", "https://example.com/1.m4a", "", time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), 95},
+				{"https://example.com/3.mp4", "Video podcast", "https://example.com/3.mp4", "", time.Time{}, 0},
+			},
+		},
+		{
+			name: "atom",
+			body: `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <title>Atom Cast</title>
+  <icon>https://example.com/icon.png</icon>
+  <entry>
+    <title>First</title>
+    <id>urn:1</id>
+    <updated>2024-03-01T12:00:00Z</updated>
+    <itunes:duration>10:00</itunes:duration>
+    <link rel="alternate" href="https://example.com/first.html" type="text/html"/>
+    <link rel="enclosure" href="https://example.com/first.pdf" type="application/pdf"/>
+    <link rel="enclosure" href="https://example.com/first.ogg" type="audio/ogg"/>
+    <link rel="enclosure" href="https://example.com/first.mp3" type="audio/mpeg"/>
+  </entry>
+  <entry>
+    <title>Page only</title>
+    <link rel="alternate" href="https://example.com/page.html"/>
+  </entry>
+  <entry>
+    <title>Second</title>
+    <published>2024-03-02T12:00:00Z</published>
+    <updated>2024-03-05T12:00:00Z</updated>
+    <link rel="enclosure" href="https://example.com/second.mp3"/>
+  </entry>
+</feed>`,
+			wantTitle: "Atom Cast",
+			wantImage: "https://example.com/icon.png",
+			episodes: []episode{
+				{"urn:1", "First", "https://example.com/first.ogg", "", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), 600},
+				{"https://example.com/second.mp3", "Second", "https://example.com/second.mp3", "", time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC), 0},
+			},
+		},
+		{
+			name: "latin-1",
+			body: "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n" +
+				"<rss><channel><title>Caf\xe9</title>" +
+				"<item><title>\xc9pisode</title><enclosure url=\"https://example.com/e.mp3\" type=\"audio/mpeg\"/></item>" +
+				"</channel></rss>",
+			wantTitle: "Café",
+			episodes: []episode{
+				{"https://example.com/e.mp3", "Épisode", "https://example.com/e.mp3", "", time.Time{}, 0},
+			},
+		},
+		{
+			name:    "html page",
+			body:    "<!DOCTYPE html><html><body>Not a feed</body></html>",
+			wantErr: ErrNotFeed,
+		},
+		{
+			name:    "empty",
+			body:    "",
+			wantErr: ErrNotFeed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed, err := parseFeed(strings.NewReader(tt.body))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("parseFeed() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFeed() error = %v", err)
+			}
+
+			if feed.Title != tt.wantTitle || feed.Image != tt.wantImage {
+				t.Errorf("parseFeed() title, image = %q, %q, want %q, %q", feed.Title, feed.Image, tt.wantTitle, tt.wantImage)
+			}
+			if len(feed.Episodes) != len(tt.episodes) {
+				t.Fatalf("parseFeed() has %d episodes, want %d", len(feed.Episodes), len(tt.episodes))
+			}
+			for i, want := range tt.episodes {
+				got := feed.Episodes[i]
+				if got.ID != want.id || got.Title != want.title || got.URL != want.url || got.Image != want.image ||
+					!got.Published.Equal(want.published) || got.Duration != want.duration {
+					t.Errorf("episode %d = %+v, want %+v", i, *got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchFeed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<rss><channel><image><url>https://example.com/show.png</url></image>`+
+			`<item><title>Old</title><pubDate>Mon, 01 Jan 2024 00:00:00 +0000</pubDate><enclosure url="https://example.com/old.mp3"/></item>`+
+			`<item><title>New</title><pubDate>Mon, 01 Apr 2024 00:00:00 +0000</pubDate><enclosure url="https://example.com/new.mp3"/></item>`+
+			`</channel></rss>`)
+	}))
+	defer server.Close()
+
+	hosts, err := NewHostFilter(HostPolicy{AllowPrivate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := FetchFeed(context.Background(), hosts, server.URL+"/feed.xml")
+	if err != nil {
+		t.Fatalf("FetchFeed() error = %v", err)
+	}
+	// Untitled feeds are named after their URL, episodes are sorted newest
+	// first and inherit the feed's image
+	if feed.Title != server.URL+"/feed.xml" {
+		t.Errorf("FetchFeed() title = %q, want the feed URL", feed.Title)
+	}
+	if len(feed.Episodes) != 2 || feed.Episodes[0].Title != "New" || feed.Episodes[1].Title != "Old" {
+		t.Fatalf("FetchFeed() episodes = %+v, want New then Old", feed.Episodes)
+	}
+	if feed.Episodes[0].Image != "https://example.com/show.png" {
+		t.Errorf("FetchFeed() episode image = %q, want the feed image", feed.Episodes[0].Image)
+	}
+
+	if _, err := FetchFeed(context.Background(), hosts, server.URL+"/missing.xml"); err == nil {
+		t.Error("FetchFeed() of a missing feed succeeded")
+	}
+
+	blocked, err := NewHostFilter(HostPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchFeed(context.Background(), blocked, server.URL+"/feed.xml"); !errors.Is(err, ErrHostBlocked) {
+		t.Errorf("FetchFeed() from a blocked host error = %v, want ErrHostBlocked", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FetchFeed(ctx, hosts, server.URL+"/feed.xml"); !errors.Is(err, context.Canceled) {
+		t.Errorf("FetchFeed() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
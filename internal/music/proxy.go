@@ -0,0 +1,176 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// hopHeaders only apply to a single connection, so the proxy doesn't pass
+// them on.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// proxy returns the URL of a local HTTP proxy that only forwards to hosts
+// the policy allows. yt-dlp and ffmpeg are pointed at it, so the requests
+// they make themselves are checked like the bot's own. It is started on
+// first use and runs until the bot exits.
+func (f *HostFilter) proxy() (string, error) {
+	f.proxyOnce.Do(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			f.proxyErr = fmt.Errorf("failed to start host policy proxy: %w", err)
+			return
+		}
+
+		server := &http.Server{
+			Handler:           &policyProxy{filter: f, transport: f.transport(ProbeTimeout)},
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go server.Serve(listener)
+		f.proxyURL = "http://" + listener.Addr().String()
+	})
+	return f.proxyURL, f.proxyErr
+}
+
+// policyProxy forwards plain HTTP requests through the policy transport
+// and tunnels HTTPS with CONNECT, checking the host first and every
+// address it connects to.
+type policyProxy struct {
+	filter    *HostFilter
+	transport http.RoundTripper
+}
+
+func (p *policyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	removeHopHeaders(out.Header)
+
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		proxyError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// Live streams never end, so pass data on as it arrives instead of
+	// waiting for buffers to fill
+	flusher := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *policyProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := p.filter.checkHost(r.Context(), host); err != nil {
+		proxyError(w, err)
+		return
+	}
+
+	upstream, err := p.filter.dialer().DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		proxyError(w, err)
+		return
+	}
+	defer upstream.Close()
+
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+
+	// Whichever side hangs up first ends the tunnel
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, buffered)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, key := range hopHeaders {
+		header.Del(key)
+	}
+}
+
+func proxyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrHostBlocked) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
@@ -0,0 +1,375 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Resolver is a source of audio: it turns user input into tracks and
+// queued tracks into audio for ffmpeg.
+type Resolver interface {
+	// CanHandle reports whether input looks like something the resolver
+	// understands. Resolve may still decline it with ErrNotHandled.
+	CanHandle(input string) bool
+
+	// Resolve returns the tracks input refers to.
+	Resolve(ctx context.Context, input string) ([]*Track, error)
+
+	// Stream opens a track's audio. Cancelling ctx stops any download or
+	// helper process behind the reader; the caller closes it when done.
+	Stream(ctx context.Context, track *Track) (io.ReadCloser, error)
+}
+
+// trackClaimer is implemented by the built-in resolvers so they can stream
+// tracks built outside Resolve, such as attachments, podcast episodes and
+// queues restored from the database.
+type trackClaimer interface {
+	claims(track *Track) bool
+}
+
+// titleSource is implemented by audio readers that announce what's playing,
+// like Icecast streams.
+type titleSource interface {
+	OnTitle(func(string))
+}
+
+var (
+	// ErrNotHandled is returned by Resolve when a resolver turns out not to
+	// understand input after all, so the next one should try.
+	ErrNotHandled = errors.New("input not handled")
+
+	ErrNoResolver = errors.New("no enabled source can play this")
+)
+
+// Resolvers is the ordered list of enabled sources. The first resolver
+// that can handle an input resolves it, so more specific resolvers go
+// first.
+type Resolvers struct {
+	list  []Resolver
+	hosts *HostFilter
+
+	// Denied, if set, is told about queued tracks refused at playback time
+	// because they are outside the guild's library or on a blocked host,
+	// so they can be audited. Set it before playback starts.
+	Denied func(guildID string, track *Track, err error)
+}
+
+// NewResolvers creates the list of sources. Every input and queued track
+// is checked against hosts before a resolver sees it.
+func NewResolvers(hosts *HostFilter, resolvers ...Resolver) *Resolvers {
+	return &Resolvers{list: resolvers, hosts: hosts}
+}
+
+// Resolve finds the tracks input refers to using the first resolver that
+// handles it. The tracks remember their resolver for playback.
+func (rs *Resolvers) Resolve(ctx context.Context, input string) ([]*Track, error) {
+	if err := rs.hosts.CheckURL(ctx, input); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rs.list {
+		if !r.CanHandle(input) {
+			continue
+		}
+
+		tracks, err := r.Resolve(ctx, input)
+		if errors.Is(err, ErrHostBlocked) {
+			// A blocked host stays blocked whichever resolver tries it
+			return nil, err
+		}
+		if errors.Is(err, ErrNotHandled) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, track := range tracks {
+			track.resolver = r
+		}
+		return tracks, nil
+	}
+	return nil, ErrNoResolver
+}
+
+// Stream opens a track's audio with the resolver that produced it, or the
+// first that claims it.
+func (rs *Resolvers) Stream(ctx context.Context, track *Track) (io.ReadCloser, error) {
+	audio, err := rs.stream(ctx, track)
+	if err != nil && rs.Denied != nil && (errors.Is(err, ErrOutsideLibrary) || errors.Is(err, ErrHostBlocked)) {
+		rs.Denied(guildFromContext(ctx), track, err)
+	}
+	return audio, err
+}
+
+func (rs *Resolvers) stream(ctx context.Context, track *Track) (io.ReadCloser, error) {
+	// Queued tracks are checked again, since the policy may have changed
+	// since they were added
+	if err := rs.hosts.CheckURL(ctx, track.URL); err != nil {
+		return nil, err
+	}
+
+	if track.resolver != nil {
+		return track.resolver.Stream(ctx, track)
+	}
+
+	for _, r := range rs.list {
+		if claimer, ok := r.(trackClaimer); ok && claimer.claims(track) {
+			return r.Stream(ctx, track)
+		}
+	}
+	return nil, ErrNoResolver
+}
+
+type guildKey struct{}
+
+// WithGuild records which guild a track is resolved or streamed for, so
+// resolvers can apply that guild's limits.
+func WithGuild(ctx context.Context, guildID string) context.Context {
+	return context.WithValue(ctx, guildKey{}, guildID)
+}
+
+func guildFromContext(ctx context.Context) string {
+	guildID, _ := ctx.Value(guildKey{}).(string)
+	return guildID
+}
+
+// ffmpegInput is returned by Stream for local files, which ffmpeg opens
+// itself rather than reading them from a pipe, since some containers need
+// seeking. It is never used for URLs, which ffmpeg would fetch without the
+// host policy.
+type ffmpegInput struct {
+	input string
+}
+
+func (in *ffmpegInput) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("%s must be opened by ffmpeg", in.input)
+}
+
+func (in *ffmpegInput) Close() error {
+	return nil
+}
+
+// LocalResolver plays files from the music library. Paths are checked
+// against the roots of the guild asking, both when resolved and when
+// played.
+type LocalResolver struct {
+	libraries *Libraries // May be nil, in which case no path is allowed
+}
+
+func NewLocalResolver(libraries *Libraries) *LocalResolver {
+	return &LocalResolver{libraries: libraries}
+}
+
+func (r *LocalResolver) CanHandle(input string) bool {
+	return IsLocalPath(input)
+}
+
+func (r *LocalResolver) Resolve(ctx context.Context, input string) ([]*Track, error) {
+	path, err := r.libraries.ResolvePath(guildFromContext(ctx), input)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := filepath.Base(path)
+	return []*Track{{
+		Title:    strings.TrimSuffix(fileName, filepath.Ext(fileName)),
+		URL:      path,
+		Duration: probeDuration(ctx, path),
+		IsLocal:  true,
+	}}, nil
+}
+
+func (r *LocalResolver) Stream(ctx context.Context, track *Track) (io.ReadCloser, error) {
+	// Check the path again, since the file or a symlink on the way to it
+	// may have changed since the track was queued. Only the roots of the
+	// guild playing it count.
+	path, err := r.libraries.ResolvePath(guildFromContext(ctx), track.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &ffmpegInput{input: path}, nil
+}
+
+func (r *LocalResolver) claims(track *Track) bool {
+	return track.IsLocal || IsLocalPath(track.URL)
+}
+
+// HTTPResolver plays audio files on the web directly, without yt-dlp:
+// links to audio files, Discord attachments and podcast episodes.
+type HTTPResolver struct {
+	client *http.Client
+}
+
+func NewHTTPResolver(hosts *HostFilter) *HTTPResolver {
+	return &HTTPResolver{client: hosts.client}
+}
+
+// CanHandle accepts http(s) links to files with an audio extension.
+func (r *HTTPResolver) CanHandle(input string) bool {
+	u, err := url.Parse(input)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return supportedExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+func (r *HTTPResolver) Resolve(ctx context.Context, input string) ([]*Track, error) {
+	u, err := url.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := path.Base(u.Path)
+	if name, err := url.PathUnescape(fileName); err == nil {
+		fileName = name
+	}
+
+	// Follow redirects here, where the host policy applies, and read the
+	// duration through the same client. ffprobe is never given the URL,
+	// since it would resolve and follow it unchecked.
+	final, size, err := r.finalURL(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Track{{
+		Title:    strings.TrimSuffix(fileName, path.Ext(fileName)),
+		URL:      input,
+		Duration: remoteDuration(ctx, r.client, final, size, path.Ext(u.Path)),
+		IsDirect: true,
+	}}, nil
+}
+
+// finalURL returns where rawURL redirects to and the size of the file
+// there, or -1 if the server doesn't say.
+func (r *HTTPResolver) finalURL(ctx context.Context, rawURL string) (string, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to reach audio file: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("failed to reach audio file: %s", resp.Status)
+	}
+	return resp.Request.URL.String(), resp.ContentLength, nil
+}
+
+// remoteDuration returns the playing time of the size byte file at rawURL
+// in whole seconds, or 0 if it can't be read from the container. Only the
+// parts holding it are downloaded, through client.
+func remoteDuration(ctx context.Context, client *http.Client, rawURL string, size int64, ext string) int {
+	if size <= 0 {
+		return 0
+	}
+	file := &remoteFile{ctx: ctx, client: client, url: rawURL}
+	seconds, err := containerDuration(file, size, ext)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return int(seconds + 0.5)
+}
+
+// remoteFile reads parts of a file on the web with range requests, so
+// containerDuration can parse it without downloading the whole file.
+type remoteFile struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+}
+
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		// The server ignored the range; don't download the whole file
+		return 0, errUnknownDuration
+	}
+
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *HTTPResolver) Stream(ctx context.Context, track *Track) (io.ReadCloser, error) {
+	// Play the cached copy once there is one
+	if track.CachePath != "" {
+		if _, err := os.Stat(track.CachePath); err == nil {
+			return &ffmpegInput{input: track.CachePath}, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, track.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download audio: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download audio: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (r *HTTPResolver) claims(track *Track) bool {
+	return track.IsDirect
+}
@@ -0,0 +1,293 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchResult is a library file with its relevance to a query.
+type SearchResult struct {
+	File  *LocalFile
+	Score float64
+}
+
+// Field weights: a hit in the title or filename counts for more than one in
+// the artist or album.
+const (
+	titleWeight  = 3.0
+	nameWeight   = 3.0
+	artistWeight = 2.0
+	albumWeight  = 1.5
+
+	// phraseBonus rewards a field containing the whole query, and
+	// exactBonus a field equal to it.
+	phraseBonus = 1.0
+	exactBonus  = 2.0
+
+	// ambiguityMargin is how far the best result must lead the runner-up
+	// to be picked without asking.
+	ambiguityMargin = 1.0
+)
+
+type searchField struct {
+	text   string // Normalized field, tokens joined by single spaces
+	tokens []string
+	weight float64
+}
+
+type searchEntry struct {
+	file   *LocalFile
+	fields []searchField
+}
+
+func newSearchEntry(file *LocalFile) searchEntry {
+	entry := searchEntry{file: file}
+	add := func(value string, weight float64) {
+		tokens := tokenize(value)
+		if len(tokens) == 0 {
+			return
+		}
+		entry.fields = append(entry.fields, searchField{
+			text:   strings.Join(tokens, " "),
+			tokens: tokens,
+			weight: weight,
+		})
+	}
+
+	add(file.Title, titleWeight)
+	name := file.Name
+	if ext := filepath.Ext(name); supportedExtensions[strings.ToLower(ext)] {
+		name = strings.TrimSuffix(name, ext)
+	}
+	add(name, nameWeight)
+	add(file.Artist, artistWeight)
+	if file.AlbumArtist != file.Artist {
+		add(file.AlbumArtist, artistWeight)
+	}
+	add(file.Album, albumWeight)
+	return entry
+}
+
+// score rates how well the entry matches the query tokens. Every query
+// token has to match some field, otherwise the score is zero.
+func (e searchEntry) score(query []string, phrase string) float64 {
+	total := 0.0
+	for _, q := range query {
+		best := 0.0
+		for _, field := range e.fields {
+			for _, t := range field.tokens {
+				if s := tokenScore(q, t) * field.weight; s > best {
+					best = s
+				}
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+
+	bonus := 0.0
+	for _, field := range e.fields {
+		switch {
+		case field.text == phrase:
+			bonus = max(bonus, exactBonus*field.weight)
+		case strings.Contains(field.text, phrase):
+			bonus = max(bonus, phraseBonus*field.weight)
+		}
+	}
+
+	return total + bonus
+}
+
+// tokenScore rates a single query token against a single field token:
+// exact matches beat prefixes, prefixes beat substrings, and typos score
+// lowest.
+func tokenScore(q, t string) float64 {
+	if q == t {
+		return 1.0
+	}
+
+	qLen := len([]rune(q))
+	if strings.HasPrefix(t, q) {
+		return 0.8
+	}
+	if qLen >= 3 && strings.Contains(t, q) {
+		return 0.6
+	}
+
+	allowed := typoAllowance(qLen)
+	if allowed == 0 {
+		return 0
+	}
+	if d := editDistance(q, t, allowed); d <= allowed {
+		return 0.7 - 0.2*float64(d)
+	}
+
+	// A typo in a partially typed word, like "stairw" for "stairway"
+	tRunes := []rune(t)
+	if len(tRunes) > qLen {
+		if d := editDistance(q, string(tRunes[:qLen]), allowed); d <= allowed {
+			return 0.5 - 0.2*float64(d)
+		}
+	}
+
+	return 0
+}
+
+// typoAllowance is the number of edits tolerated for a token of the given
+// length. Short tokens must match exactly to keep results relevant.
+func typoAllowance(length int) int {
+	switch {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b, counting insertions, deletions, substitutions and transpositions. It
+// gives up early and returns limit+1 once the distance exceeds limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > limit || -diff > limit {
+		return limit + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	return prev[len(rb)]
+}
+
+// tokenize lowercases s, folds accented letters to their base letter and
+// splits it into words.
+func tokenize(s string) []string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if folded, ok := accentFolds[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if unicode.Is(unicode.Mn, r) {
+			// Combining marks from decomposed text
+			continue
+		}
+		if r == '\'' || r == '’' {
+			// "don't" and "dont" should match
+			continue
+		}
+		b.WriteByte(' ')
+	}
+	return strings.Fields(b.String())
+}
+
+// accentFolds maps lowercase accented Latin letters to plain ASCII.
+var accentFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'ţ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// rank scores entries against query and returns the matches in relevance
+// order, ties broken by filename.
+func rank(entries []searchEntry, query string) []SearchResult {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+	phrase := strings.Join(tokens, " ")
+
+	var results []SearchResult
+	for _, entry := range entries {
+		if score := entry.score(tokens, phrase); score > 0 {
+			results = append(results, SearchResult{File: entry.file, Score: score})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].File.Name < results[j].File.Name
+	})
+	return results
+}
+
+// Search ranks library files by how well their title, filename, artist and
+// album match query. Matching ignores case and accents and tolerates small
+// typos.
+func (l *Library) Search(query string) []SearchResult {
+	return rank(l.browse().search, query)
+}
+
+// SearchFolder is like Search but only considers files in one folder.
+func (l *Library) SearchFolder(folder, query string) []SearchResult {
+	return rank(l.browse().searchByFolder[folder], query)
+}
+
+// Unambiguous reports whether the best result clearly beats the rest.
+func Unambiguous(results []SearchResult) bool {
+	return len(results) == 1 ||
+		(len(results) > 1 && results[0].Score-results[1].Score >= ambiguityMargin)
+}
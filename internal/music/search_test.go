@@ -0,0 +1,166 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Café Déjà Vu", []string{"cafe", "deja", "vu"}},
+		{"Cafe\u0301", []string{"cafe"}}, // Decomposed é
+		{"Don't Stop / Ærøskøbing", []string{"dont", "stop", "aeroskobing"}},
+		{"Straße_2 (Live)", []string{"strasse", "2", "live"}},
+		{"初音ミク - 千本桜", []string{"初音ミク", "千本桜"}},
+		{"  --  ", nil},
+	}
+
+	for _, tt := range tests {
+		if got := tokenize(tt.input); !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"miku", "miku", 2, 0},
+		{"miku", "mku", 2, 1},
+		{"miku", "mikuu", 2, 1},
+		{"miku", "niku", 2, 1},
+		{"miku", "imku", 2, 1},
+		{"stairway", "stiarwya", 2, 2},
+		{"hatsune", "miku", 2, 3},
+		{"abc", "abcdef", 1, 2},
+		{"ミク", "ミグ", 1, 1},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestTokenScore(t *testing.T) {
+	tests := []struct {
+		query, token string
+		want         float64
+	}{
+		{"miku", "miku", 1.0},
+		{"mi", "miku", 0.8},
+		{"iku", "miku", 0.6},
+		{"ik", "miku", 0},
+		{"mikv", "miku", 0.5},
+		{"stiarway", "stairway", 0.5},
+		{"stairw", "stairway", 0.8},
+		{"stiarw", "stairway", 0.3},
+		{"cat", "cut", 0},
+		{"rolling", "stones", 0},
+	}
+
+	for _, tt := range tests {
+		if got := tokenScore(tt.query, tt.token); !almostEqual(got, tt.want) {
+			t.Errorf("tokenScore(%q, %q) = %v, want %v", tt.query, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	files := []*LocalFile{
+		{Name: "01 - Senbonzakura.flac", Title: "Senbonzakura", Artist: "Kurousa-P", Album: "Vocaloid Hits"},
+		{Name: "02 - World is Mine.flac", Title: "World is Mine", Artist: "ryo", Album: "supercell"},
+		{Name: "03 - Melt.flac", Title: "Melt", Artist: "ryo", AlbumArtist: "supercell", Album: "Vocaloid Hits"},
+		{Name: "04 - Rolling Girl.mp3", Title: "Rolling Girl", Artist: "wowaka", Album: "Unhappy Refrain"},
+		{Name: "05 - Unknown Mother-Goose.mp3", Title: "Unknown Mother-Goose", Artist: "wowaka", Album: "Unhappy Refrain"},
+		{Name: "06 - Café.mp3", Title: "Café", Artist: "Nobody"},
+	}
+	entries := make([]searchEntry, len(files))
+	for i, file := range files {
+		entries[i] = newSearchEntry(file)
+	}
+
+	tests := []struct {
+		query string
+		want  []string // Titles in ranking order
+	}{
+		{"melt", []string{"Melt"}},
+		{"mine world", []string{"World is Mine"}},
+		{"ryo", []string{"World is Mine", "Melt"}},       // Tie, broken by filename
+		{"supercell", []string{"Melt", "World is Mine"}}, // Album artist beats album
+		{"wowaka rolling", []string{"Rolling Girl"}},
+		{"senbonzakrua", []string{"Senbonzakura"}},
+		{"cafe", []string{"Café"}},
+		{"vocaloid", []string{"Senbonzakura", "Melt"}},
+		{"girl rolling", []string{"Rolling Girl"}},
+		{"ryo wowaka", nil},
+		{"!!", nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, result := range rank(entries, tt.query) {
+			got = append(got, result.File.Title)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("rank(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestUnambiguous(t *testing.T) {
+	tests := []struct {
+		scores []float64
+		want   bool
+	}{
+		{nil, false},
+		{[]float64{1}, true},
+		{[]float64{5, 3.9}, true},
+		{[]float64{5, 4}, true},
+		{[]float64{5, 4.5}, false},
+	}
+
+	for _, tt := range tests {
+		results := make([]SearchResult, len(tt.scores))
+		for i, score := range tt.scores {
+			results[i] = SearchResult{File: &LocalFile{}, Score: score}
+		}
+		if got := Unambiguous(results); got != tt.want {
+			t.Errorf("Unambiguous(%v) = %v, want %v", tt.scores, got, tt.want)
+		}
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	const epsilon = 1e-9
+	return a-b < epsilon && b-a < epsilon
+}
@@ -0,0 +1,157 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Source is where a track comes from, as switched on and off under
+// sources in the config.
+type Source string
+
+const (
+	SourceYouTube    Source = "youtube"
+	SourceSoundCloud Source = "soundcloud"
+	SourceBandcamp   Source = "bandcamp"
+	SourceVimeo      Source = "vimeo"
+	SourceTwitch     Source = "twitch"
+	SourceHTTP       Source = "http"
+	SourceLocal      Source = "local"
+)
+
+// AllSources lists every source in the order they are shown to users.
+var AllSources = []Source{
+	SourceYouTube,
+	SourceSoundCloud,
+	SourceBandcamp,
+	SourceVimeo,
+	SourceTwitch,
+	SourceHTTP,
+	SourceLocal,
+}
+
+var sourceNames = map[Source]string{
+	SourceYouTube:    "YouTube",
+	SourceSoundCloud: "SoundCloud",
+	SourceBandcamp:   "Bandcamp",
+	SourceVimeo:      "Vimeo",
+	SourceTwitch:     "Twitch",
+	SourceHTTP:       "HTTP URLs",
+	SourceLocal:      "Local files",
+}
+
+// sourceHosts maps site domains to their source. Subdomains match too.
+var sourceHosts = map[string]Source{
+	"youtube.com":          SourceYouTube,
+	"youtu.be":             SourceYouTube,
+	"youtube-nocookie.com": SourceYouTube,
+	"soundcloud.com":       SourceSoundCloud,
+	"snd.sc":               SourceSoundCloud,
+	"bandcamp.com":         SourceBandcamp,
+	"vimeo.com":            SourceVimeo,
+	"twitch.tv":            SourceTwitch,
+}
+
+// searchPrefixes maps yt-dlp search prefixes to the site they search.
+var searchPrefixes = map[string]Source{
+	"ytsearch": SourceYouTube,
+	"scsearch": SourceSoundCloud,
+}
+
+// String returns the source's display name.
+func (s Source) String() string {
+	if name, ok := sourceNames[s]; ok {
+		return name
+	}
+	return string(s)
+}
+
+// ParseSource looks up a source by its config name.
+func ParseSource(name string) (Source, bool) {
+	source := Source(strings.ToLower(name))
+	_, ok := sourceNames[source]
+	return source, ok
+}
+
+// ClassifyInput returns the source a play request would use: local paths,
+// yt-dlp searches, links to the sites yt-dlp is enabled for, and anything
+// else on the web, which counts as HTTP. It returns "" for input it can't
+// place, such as an unknown URL scheme.
+func ClassifyInput(input string) Source {
+	if IsLocalPath(input) {
+		return SourceLocal
+	}
+
+	// Searches look like ytsearch:query, ytsearch5:query or ytsearchdate:query
+	if scheme, _, ok := strings.Cut(strings.ToLower(input), ":"); ok {
+		for prefix, source := range searchPrefixes {
+			if strings.HasPrefix(scheme, prefix) {
+				return source
+			}
+		}
+	}
+
+	u, err := url.Parse(input)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for {
+		if source, ok := sourceHosts[host]; ok {
+			return source
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return SourceHTTP
+		}
+		host = parent
+	}
+}
+
+// SourceSet is the set of enabled sources.
+type SourceSet map[Source]bool
+
+// Names returns the display names of the enabled sources.
+func (set SourceSet) Names() []string {
+	var names []string
+	for _, source := range AllSources {
+		if set[source] {
+			names = append(names, source.String())
+		}
+	}
+	return names
+}
+
+// Without returns a copy of the set with the given sources switched off.
+func (set SourceSet) Without(sources ...Source) SourceSet {
+	result := make(SourceSet, len(set))
+	for source, enabled := range set {
+		result[source] = enabled
+	}
+	for _, source := range sources {
+		delete(result, source)
+	}
+	return result
+}
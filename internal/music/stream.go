@@ -0,0 +1,390 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeTimeout bounds how long a server gets to answer, and callers of
+// ProbeStream use it to bound the whole probe. Live streams never finish,
+// so only the headers (or a playlist) are read.
+const ProbeTimeout = 10 * time.Second
+
+// maxStreamPlaylist caps how much of a .pls/.m3u/.m3u8 response is read.
+const maxStreamPlaylist = 64 << 10
+
+// StreamInfo describes a live stream found by ProbeStream.
+type StreamInfo struct {
+	URL  string // Where the audio is, after following station playlists
+	Name string // Station name from the icy-name header, if any
+	HLS  bool
+}
+
+// Track returns a live track for the stream. title, if set, overrides the
+// station name.
+func (info *StreamInfo) Track(title, requester string) *Track {
+	if title == "" {
+		title = info.Name
+	}
+	if title == "" {
+		title = info.URL
+	}
+	return &Track{
+		Title:     title,
+		URL:       info.URL,
+		Requester: requester,
+		IsLive:    true,
+	}
+}
+
+// playlistTypes are the content types Shoutcast and Icecast directories use
+// for station playlists that point at the actual stream.
+var playlistTypes = map[string]bool{
+	"audio/x-scpls":         true,
+	"audio/scpls":           true,
+	"audio/x-mpegurl":       true,
+	"audio/mpegurl":         true,
+	"application/pls+xml":   true,
+	"application/x-mpegurl": true,
+}
+
+var hlsTypes = map[string]bool{
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"audio/x-mpegurl":               true,
+}
+
+// ProbeStream checks whether rawURL is a live stream: an Icecast or
+// Shoutcast server, a station playlist pointing at one, or an HLS playlist
+// that hasn't ended. It returns nil for anything else, such as a web page
+// or an ordinary audio file. Every request is checked against hosts.
+func ProbeStream(ctx context.Context, hosts *HostFilter, rawURL string) (*StreamInfo, error) {
+	return probeStream(ctx, hosts, rawURL, 2)
+}
+
+func probeStream(ctx context.Context, hosts *HostFilter, rawURL string, depth int) (*StreamInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Icy-MetaData", "1")
+
+	resp, err := hosts.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+
+	// Carry on from wherever redirects led
+	u = resp.Request.URL
+	rawURL = u.String()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext := strings.ToLower(path.Ext(u.Path))
+
+	if ext == ".m3u8" || ext == ".m3u" || ext == ".pls" || hlsTypes[mediaType] || playlistTypes[mediaType] {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxStreamPlaylist))
+		text := string(body)
+
+		// HLS playlists are live until they carry an end marker
+		if strings.Contains(text, "#EXT-X-TARGETDURATION") || strings.Contains(text, "#EXT-X-STREAM-INF") {
+			if strings.Contains(text, "#EXT-X-ENDLIST") {
+				return nil, nil
+			}
+			// Refuse playlists pointing at blocked hosts up front; playback
+			// checks every later request too
+			if err := checkPlaylistHosts(ctx, hosts, u, text); err != nil {
+				return nil, err
+			}
+			return &StreamInfo{URL: rawURL, Name: u.Host, HLS: true}, nil
+		}
+		return followPlaylist(ctx, hosts, u, text, depth)
+	}
+
+	// Icecast and Shoutcast announce themselves with icy-* headers. A
+	// missing length proves nothing, since chunked and compressed files
+	// have none either, so audio without them is left to the other
+	// resolvers.
+	if resp.Header.Get("icy-name") != "" || resp.Header.Get("icy-metaint") != "" || resp.Header.Get("icy-br") != "" {
+		return &StreamInfo{URL: rawURL, Name: resp.Header.Get("icy-name")}, nil
+	}
+
+	return nil, nil
+}
+
+// checkPlaylistHosts checks every URL an HLS playlist refers to against
+// the host policy.
+func checkPlaylistHosts(ctx context.Context, hosts *HostFilter, base *url.URL, body string) error {
+	checked := map[string]bool{base.Host: true}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ref, err := base.Parse(line)
+		if err != nil || checked[ref.Host] {
+			continue
+		}
+		checked[ref.Host] = true
+		if err := hosts.CheckURL(ctx, ref.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followPlaylist probes the first stream a station playlist lists.
+func followPlaylist(ctx context.Context, hosts *HostFilter, base *url.URL, body string, depth int) (*StreamInfo, error) {
+	if depth == 0 {
+		return nil, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		// PLS entries look like File1=http://...; its other keys are
+		// skipped
+		if key, value, ok := strings.Cut(line, "="); ok && !strings.ContainsAny(key, "/:?") {
+			if !strings.HasPrefix(strings.ToLower(key), "file") {
+				continue
+			}
+			line = strings.TrimSpace(value)
+		}
+
+		ref, err := base.Parse(line)
+		if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+			continue
+		}
+		return probeStream(ctx, hosts, ref.String(), depth-1)
+	}
+	return nil, nil
+}
+
+// icyReader strips the metadata blocks Icecast and Shoutcast interleave
+// with the audio every metaint bytes, passing each StreamTitle to onTitle.
+type icyReader struct {
+	r         *bufio.Reader
+	metaint   int
+	remaining int // Audio bytes left before the next metadata block
+	onTitle   func(string)
+}
+
+func newIcyReader(r io.Reader, metaint int, onTitle func(string)) *icyReader {
+	return &icyReader{
+		r:         bufio.NewReader(r),
+		metaint:   metaint,
+		remaining: metaint,
+		onTitle:   onTitle,
+	}
+}
+
+func (ir *icyReader) Read(p []byte) (int, error) {
+	if ir.remaining == 0 {
+		if err := ir.readMetadata(); err != nil {
+			return 0, err
+		}
+		ir.remaining = ir.metaint
+	}
+
+	if len(p) > ir.remaining {
+		p = p[:ir.remaining]
+	}
+	n, err := ir.r.Read(p)
+	ir.remaining -= n
+	return n, err
+}
+
+func (ir *icyReader) readMetadata() error {
+	size, err := ir.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	block := make([]byte, int(size)*16)
+	if _, err := io.ReadFull(ir.r, block); err != nil {
+		return err
+	}
+
+	if title, ok := parseStreamTitle(string(block)); ok {
+		ir.onTitle(title)
+	}
+	return nil
+}
+
+// parseStreamTitle extracts StreamTitle from a metadata block such as
+// "StreamTitle='Artist - Song';StreamUrl='http://example.com';". Titles
+// may contain quotes, so the value runs up to the "';" that ends it.
+func parseStreamTitle(block string) (string, bool) {
+	block = strings.TrimRight(block, "\x00")
+	_, rest, ok := strings.Cut(block, "StreamTitle='")
+	if !ok {
+		return "", false
+	}
+	if end := strings.Index(rest, "';"); end >= 0 {
+		rest = rest[:end]
+	} else {
+		rest = strings.TrimSuffix(rest, "'")
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// icyStream is an Icecast or Shoutcast response body with the metadata
+// stripped out.
+type icyStream struct {
+	*icyReader
+	body io.Closer
+}
+
+func (st *icyStream) Close() error {
+	return st.body.Close()
+}
+
+// OnTitle sets the function told about each StreamTitle. It must be called
+// before reading starts.
+func (st *icyStream) OnTitle(onTitle func(string)) {
+	st.onTitle = onTitle
+}
+
+// RadioResolver plays Icecast, Shoutcast and HLS live streams. Since any
+// http(s) URL might be one, Resolve probes the URL and declines anything
+// that isn't live.
+type RadioResolver struct {
+	hosts *HostFilter
+}
+
+func NewRadioResolver(hosts *HostFilter) *RadioResolver {
+	return &RadioResolver{hosts: hosts}
+}
+
+// CanHandle accepts http(s) URLs, except links to the sites yt-dlp plays,
+// which would only cost a wasted request.
+func (r *RadioResolver) CanHandle(input string) bool {
+	return ClassifyInput(input) == SourceHTTP
+}
+
+func (r *RadioResolver) Resolve(ctx context.Context, input string) ([]*Track, error) {
+	info, err := ProbeStream(ctx, r.hosts, input)
+	if errors.Is(err, ErrHostBlocked) {
+		// Don't let another resolver follow the same redirect
+		return nil, err
+	}
+	if err != nil || info == nil {
+		return nil, ErrNotHandled
+	}
+	return []*Track{info.Track("", "")}, nil
+}
+
+// Stream connects to the stream, asking for metadata and stripping it from
+// the audio if the server sends it. HLS playlists are fetched by a helper
+// ffmpeg through the host policy proxy, which remuxes the segments into
+// one stream for the player.
+func (r *RadioResolver) Stream(ctx context.Context, track *Track) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, track.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Icy-MetaData", "1")
+
+	resp, err := r.hosts.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to stream: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("stream returned %s", resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if hlsTypes[mediaType] || strings.EqualFold(path.Ext(resp.Request.URL.Path), ".m3u8") {
+		resp.Body.Close()
+		return r.streamHLS(ctx, resp.Request.URL.String())
+	}
+
+	metaint, err := strconv.Atoi(resp.Header.Get("icy-metaint"))
+	if err != nil || metaint <= 0 {
+		return resp.Body, nil
+	}
+
+	return &icyStream{icyReader: newIcyReader(resp.Body, metaint, func(string) {}), body: resp.Body}, nil
+}
+
+// hlsProtocols are the only protocols the HLS helper may open. The proxy
+// is reached over tcp; http and tls then go through it.
+const hlsProtocols = "http,https,tcp,tls,crypto,httpproxy"
+
+func (r *RadioResolver) streamHLS(ctx context.Context, playlistURL string) (io.ReadCloser, error) {
+	proxy, err := r.hosts.proxy()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-v", "error",
+		"-protocol_whitelist", hlsProtocols,
+		"-http_proxy", proxy,
+		"-i", playlistURL,
+		"-map", "0:a:0",
+		"-c", "copy",
+		"-f", "mpegts",
+		"-",
+	)
+	// Nested protocols like tls may not see -http_proxy, but all of them
+	// fall back to the environment
+	cmd.Env = append(os.Environ(), "http_proxy="+proxy, "no_proxy=")
+
+	return startCommand(cmd)
+}
+
+func (r *RadioResolver) claims(track *Track) bool {
+	return track.IsLive
+}
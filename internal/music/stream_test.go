@@ -0,0 +1,215 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseStreamTitle(t *testing.T) {
+	tests := []struct {
+		block  string
+		want   string
+		wantOK bool
+	}{
+		{"StreamTitle='Artist - Song';StreamUrl='http://example.com';", "Artist - Song", true},
+		{"StreamTitle='Artist - Song';\x00\x00\x00\x00", "Artist - Song", true},
+		{"StreamTitle='Don't Stop';", "Don't Stop", true},
+		{"StreamTitle='No Terminator'", "No Terminator", true},
+		{"StreamTitle='  Padded  ';", "Padded", true},
+		{"StreamTitle='';", "", true},
+		{"StreamUrl='http://example.com';", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseStreamTitle(tt.block)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseStreamTitle(%q) = %q, %v, want %q, %v", tt.block, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// icyBlock returns a metadata block as Icecast sends it: a length byte
+// counting 16-byte units, then the padded text.
+func icyBlock(text string) string {
+	units := (len(text) + 15) / 16
+	return string([]byte{byte(units)}) + text + strings.Repeat("\x00", units*16-len(text))
+}
+
+func TestIcyReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		metaint   int
+		stream    string
+		bufSize   int
+		want      string
+		wantTitle []string
+		wantErr   bool
+	}{
+		{
+			name:      "titles between audio",
+			metaint:   4,
+			stream:    "abcd" + icyBlock("StreamTitle='One';") + "efgh" + "\x00" + "ijkl" + icyBlock("StreamTitle='Two';") + "mn",
+			bufSize:   64,
+			want:      "abcdefghijklmn",
+			wantTitle: []string{"One", "Two"},
+		},
+		{
+			name:      "small reads",
+			metaint:   3,
+			stream:    "abc" + icyBlock("StreamTitle='A long title that spans blocks';") + "def",
+			bufSize:   1,
+			want:      "abcdef",
+			wantTitle: []string{"A long title that spans blocks"},
+		},
+		{
+			name:    "block without title",
+			metaint: 2,
+			stream:  "ab" + icyBlock("StreamUrl='x';") + "cd",
+			bufSize: 64,
+			want:    "abcd",
+		},
+		{
+			name:    "truncated block",
+			metaint: 2,
+			stream:  "ab\x02StreamTitle",
+			bufSize: 64,
+			want:    "ab",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var titles []string
+			reader := newIcyReader(strings.NewReader(tt.stream), tt.metaint, func(title string) {
+				titles = append(titles, title)
+			})
+
+			var audio bytes.Buffer
+			buf := make([]byte, tt.bufSize)
+			var err error
+			for {
+				var n int
+				n, err = reader.Read(buf)
+				audio.Write(buf[:n])
+				if err != nil {
+					break
+				}
+			}
+
+			if gotErr := err != io.EOF; gotErr != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if audio.String() != tt.want {
+				t.Errorf("audio = %q, want %q", audio.String(), tt.want)
+			}
+			if !slices.Equal(titles, tt.wantTitle) {
+				t.Errorf("titles = %q, want %q", titles, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func TestProbeStream(t *testing.T) {
+	hosts, err := NewHostFilter(HostPolicy{AllowPrivate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/icecast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-name", "Miku Radio")
+		w.Write([]byte("audio"))
+	})
+	mux.HandleFunc("/chunked.mp3", func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Length, like any chunked or compressed file
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.(http.Flusher).Flush()
+		w.Write([]byte("audio"))
+	})
+	mux.HandleFunc("/station.pls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/x-scpls")
+		fmt.Fprint(w, "[playlist]\nNumberOfEntries=1\nTitle1=Miku Radio\nFile1=/icecast\n")
+	})
+	mux.HandleFunc("/loop.m3u", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n/loop.m3u\n")
+	})
+	mux.HandleFunc("/live.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6,\nseg1.ts\n")
+	})
+	mux.HandleFunc("/vod.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6,\nseg1.ts\n#EXT-X-ENDLIST\n")
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html></html>")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		want     string // Stream path, or "" for not live
+		wantName string
+		wantHLS  bool
+	}{
+		{"/icecast", "/icecast", "Miku Radio", false},
+		{"/chunked.mp3", "", "", false},
+		{"/station.pls", "/icecast", "Miku Radio", false},
+		{"/loop.m3u", "", "", false},
+		{"/live.m3u8", "/live.m3u8", server.Listener.Addr().String(), true},
+		{"/vod.m3u8", "", "", false},
+		{"/page", "", "", false},
+		{"/missing", "", "", false},
+	}
+
+	for _, tt := range tests {
+		info, err := ProbeStream(context.Background(), hosts, server.URL+tt.path)
+		if err != nil {
+			t.Errorf("ProbeStream(%s) error = %v", tt.path, err)
+			continue
+		}
+		if tt.want == "" {
+			if info != nil {
+				t.Errorf("ProbeStream(%s) = %+v, want nil", tt.path, *info)
+			}
+			continue
+		}
+		if info == nil {
+			t.Errorf("ProbeStream(%s) = nil, want %s", tt.path, tt.want)
+			continue
+		}
+		if info.URL != server.URL+tt.want || info.Name != tt.wantName || info.HLS != tt.wantHLS {
+			t.Errorf("ProbeStream(%s) = %+v, want URL %s, name %q, HLS %v", tt.path, *info, server.URL+tt.want, tt.wantName, tt.wantHLS)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"miku_bot/internal/database"
+)
+
+const (
+	// watchDebounce is how long the music folder has to stay quiet before
+	// queued changes are applied, so copying an album is handled in one go.
+	watchDebounce = 2 * time.Second
+
+	// pollInterval is how often the library is fully rescanned when the
+	// folder can't be watched.
+	pollInterval = 15 * time.Minute
+)
+
+// folderWatcher reports changes below the library root. Platform specific
+// implementations live in watch_*.go.
+type folderWatcher interface {
+	// Changes delivers paths of files or directories that were created,
+	// modified, moved or removed.
+	Changes() <-chan string
+	// Overflow signals that events were dropped and a rescan is needed.
+	Overflow() <-chan struct{}
+	// Errors delivers failures that stop the watcher from being reliable,
+	// such as running out of watches.
+	Errors() <-chan error
+	Close() error
+}
+
+// Watch keeps the library in sync with the music folder until Close is
+// called. If the folder can't be watched, it falls back to periodic full
+// rescans.
+func (l *Library) Watch() {
+	w, err := newFolderWatcher(l.rootPath)
+	if err != nil {
+		log.Printf("Not watching music folder %s (%v), rescanning every %s instead", l.rootPath, err, pollInterval)
+		go l.pollLoop()
+		return
+	}
+
+	go l.watchLoop(w)
+}
+
+// Close stops watching the music folder and cancels any running scan.
+func (l *Library) Close() {
+	l.cancel()
+}
+
+func (l *Library) watchLoop(w folderWatcher) {
+	defer w.Close()
+
+	pending := make(map[string]bool)
+	flush := time.NewTimer(watchDebounce)
+	flush.Stop()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case path, ok := <-w.Changes():
+			if !ok {
+				return
+			}
+			pending[path] = true
+			flush.Reset(watchDebounce)
+		case <-flush.C:
+			if needsRescan := l.applyChanges(pending); needsRescan {
+				// CUE sheets and cover images change other files'
+				// entries, so rebuild the library from scratch
+				l.rescan()
+			}
+			pending = make(map[string]bool)
+		case <-w.Overflow():
+			log.Println("Music folder watcher dropped events, running a full rescan")
+			pending = make(map[string]bool)
+			l.rescan()
+		case err := <-w.Errors():
+			log.Printf("Music folder watcher for %s failed (%v), falling back to rescanning every %s", l.rootPath, err, pollInterval)
+			w.Close()
+			l.rescan()
+			l.pollLoop()
+			return
+		}
+	}
+}
+
+func (l *Library) pollLoop() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+			l.rescan()
+		}
+	}
+}
+
+func (l *Library) rescan() {
+	result, err := l.Scan(l.ctx, nil)
+	if err != nil {
+		log.Printf("Rescan of library %q failed: %v", l.name, err)
+		return
+	}
+	if result.Added+result.Updated+result.Removed > 0 {
+		log.Printf("Library %q rescanned: %d added, %d updated, %d removed", l.name, result.Added, result.Updated, result.Removed)
+	}
+}
+
+// applyChanges updates the library for a batch of changed paths. Paths that
+// no longer exist are removed along with everything below them; directories
+// are walked for new or changed files. It reports whether a CUE sheet or
+// cover image was among the changes, which needs a full rescan.
+func (l *Library) applyChanges(paths map[string]bool) (needsRescan bool) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	var updated []*database.LibraryFile
+	var removed []string
+
+	for path := range paths {
+		if isCueSheet(path) || isSidecar(path) {
+			needsRescan = true
+		}
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			removed = append(removed, l.removeEntries(path)...)
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to stat %s: %v", path, err)
+			continue
+		}
+
+		if !info.IsDir() {
+			if record := l.refreshFile(l.ctx, path, info); record != nil {
+				updated = append(updated, record)
+			}
+			continue
+		}
+
+		filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if isCueSheet(p) || isSidecar(p) {
+				needsRescan = true
+			}
+			if info, err := d.Info(); err == nil {
+				if record := l.refreshFile(l.ctx, p, info); record != nil {
+					updated = append(updated, record)
+				}
+			}
+			return nil
+		})
+	}
+
+	// Records read while closing may be missing their durations
+	if l.ctx.Err() != nil {
+		return false
+	}
+
+	if len(updated) > 0 {
+		if err := l.db.SaveLibraryFiles(updated); err != nil {
+			log.Printf("Failed to update library index: %v", err)
+		}
+	}
+	if len(removed) > 0 {
+		if err := l.db.DeleteLibraryFiles(removed); err != nil {
+			log.Printf("Failed to prune library index: %v", err)
+		}
+	}
+
+	if len(updated)+len(removed) > 0 {
+		log.Printf("Music folder %s changed: %d files added or updated, %d removed", l.rootPath, len(updated), len(removed))
+	}
+	return needsRescan
+}
+
+// refreshFile re-reads a supported file if it is new or changed and puts it
+// in the library. It returns the new index record, or nil if nothing changed.
+func (l *Library) refreshFile(ctx context.Context, path string, info fs.FileInfo) *database.LibraryFile {
+	if playlist := l.newPlaylist(path); playlist != nil {
+		l.mu.Lock()
+		l.playlists[path] = playlist
+		l.mu.Unlock()
+		return nil
+	}
+
+	if !supportedExtensions[strings.ToLower(filepath.Ext(path))] || !l.contains(path) {
+		return nil
+	}
+
+	l.mu.RLock()
+	existing := l.findLocked(path)
+	l.mu.RUnlock()
+
+	if existing != nil && existing.size == info.Size() && existing.modTime == info.ModTime().UnixNano() {
+		return nil
+	}
+
+	record := l.readRecord(ctx, path, info)
+	file := l.localFile(record)
+	if file == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.removeLocked(func(f *LocalFile) bool { return f.Path == path })
+	entries := expandCue(file, l.cues)
+	l.files[file.Folder] = append(l.files[file.Folder], entries...)
+	l.byPath[path] = entries
+	l.index = nil
+	return record
+}
+
+// removeEntries drops the file at path, or every file below it if it was a
+// directory, and returns the removed paths. Playlists are dropped too but
+// not returned, since they aren't in the persistent index.
+func (l *Library) removeEntries(path string) []string {
+	prefix := path + string(filepath.Separator)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for playlistPath := range l.playlists {
+		if playlistPath == path || strings.HasPrefix(playlistPath, prefix) {
+			delete(l.playlists, playlistPath)
+		}
+	}
+
+	return l.removeLocked(func(f *LocalFile) bool {
+		return f.Path == path || strings.HasPrefix(f.Path, prefix)
+	})
+}
+
+func (l *Library) findLocked(path string) *LocalFile {
+	if entries := l.byPath[path]; len(entries) > 0 {
+		return entries[0]
+	}
+	return nil
+}
+
+func (l *Library) removeLocked(match func(*LocalFile) bool) []string {
+	var removed []string
+	seen := make(map[string]bool)
+	for folder, files := range l.files {
+		kept := files[:0]
+		for _, file := range files {
+			if match(file) {
+				// CUE tracks share their file's path
+				if !seen[file.Path] {
+					seen[file.Path] = true
+					removed = append(removed, file.Path)
+					delete(l.byPath, file.Path)
+				}
+				continue
+			}
+			kept = append(kept, file)
+		}
+
+		if len(kept) == 0 {
+			delete(l.files, folder)
+		} else {
+			l.files[folder] = kept
+		}
+	}
+
+	if len(removed) > 0 {
+		l.index = nil
+	}
+	return removed
+}
@@ -0,0 +1,194 @@
+//go:build linux
+
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// inotifyWatcher watches every directory under the library root with one
+// inotify watch each, adding watches for directories as they appear.
+type inotifyWatcher struct {
+	file     *os.File
+	dirs     map[int32]string // watch descriptor -> directory
+	mu       sync.Mutex
+	changes  chan string
+	overflow chan struct{}
+	errors   chan error
+	done     chan struct{}
+	closeOne sync.Once
+}
+
+func newFolderWatcher(root string) (folderWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	w := &inotifyWatcher{
+		// A non-blocking descriptor lets the runtime poller service reads,
+		// so Close unblocks the read loop
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		dirs:     make(map[int32]string),
+		changes:  make(chan string, 256),
+		overflow: make(chan struct{}, 1),
+		errors:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.readLoop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Changes() <-chan string    { return w.changes }
+func (w *inotifyWatcher) Overflow() <-chan struct{} { return w.overflow }
+func (w *inotifyWatcher) Errors() <-chan error      { return w.errors }
+
+func (w *inotifyWatcher) Close() error {
+	w.closeOne.Do(func() { close(w.done) })
+	return w.file.Close()
+}
+
+// addTree watches dir and every directory below it. Running out of watches
+// (ENOSPC) is reported as an error because changes in unwatched directories
+// would silently go missing.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(int(w.file.Fd()), path, inotifyMask)
+		if err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				return fmt.Errorf("inotify watch limit reached (raise fs.inotify.max_user_watches): %w", err)
+			}
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+
+		w.mu.Lock()
+		w.dirs[int32(wd)] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) readLoop() {
+	defer close(w.changes)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.fail(fmt.Errorf("failed to read inotify events: %w", err))
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			w.handleEvent(event, trimNul(nameBytes))
+		}
+	}
+}
+
+func (w *inotifyWatcher) handleEvent(event *syscall.InotifyEvent, name string) {
+	if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		select {
+		case w.overflow <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	w.mu.Lock()
+	dir, ok := w.dirs[event.Wd]
+	if event.Mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, event.Wd)
+	}
+	w.mu.Unlock()
+
+	if !ok || name == "" {
+		// Events about the watched directory itself are covered by the
+		// event its parent receives
+		return
+	}
+
+	path := filepath.Join(dir, name)
+
+	// New directories need watches of their own before their contents can
+	// be tracked
+	if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if err := w.addTree(path); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+
+	select {
+	case w.changes <- path:
+	case <-w.done:
+	}
+}
+
+func (w *inotifyWatcher) fail(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
+
+func trimNul(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
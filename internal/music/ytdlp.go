@@ -0,0 +1,165 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// YTDLPResolver plays anything yt-dlp supports: YouTube, SoundCloud,
+// Bandcamp, Vimeo, Twitch, most other sites, and "ytsearch:" queries.
+type YTDLPResolver struct {
+	hosts *HostFilter
+}
+
+func NewYTDLPResolver(hosts *HostFilter) *YTDLPResolver {
+	return &YTDLPResolver{hosts: hosts}
+}
+
+type videoInfo struct {
+	Title      string `json:"title"`
+	URL        string `json:"url"`
+	WebpageURL string `json:"webpage_url"`
+	Duration   int    `json:"duration"`
+	Thumbnail  string `json:"thumbnail"`
+}
+
+// args returns the options every yt-dlp call shares. yt-dlp sends all its
+// requests through the host policy proxy, so the pages it reads, the hosts
+// the audio comes from and every redirect in between are checked.
+func (r *YTDLPResolver) args() ([]string, error) {
+	proxy, err := r.hosts.proxy()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"--no-playlist",
+		"--format", "bestaudio",
+		"--proxy", proxy,
+	}
+
+	// Add API keys if available (helps avoid rate limiting)
+	if youtubeKey := os.Getenv("YOUTUBE_API_KEY"); youtubeKey != "" {
+		args = append(args, "--username", "oauth2", "--password", "")
+	}
+
+	if soundcloudAuth := os.Getenv("SOUNDCLOUD_AUTH_TOKEN"); soundcloudAuth != "" {
+		args = append(args, "--add-header", "Authorization:OAuth "+soundcloudAuth)
+	}
+
+	return args, nil
+}
+
+// CanHandle accepts everything but local paths; yt-dlp is the fallback
+// for URLs and searches the other resolvers don't take.
+func (r *YTDLPResolver) CanHandle(input string) bool {
+	return !IsLocalPath(input)
+}
+
+func (r *YTDLPResolver) Resolve(ctx context.Context, input string) ([]*Track, error) {
+	args, err := r.args()
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "--dump-json", "--", input)
+
+	output, err := exec.CommandContext(ctx, "yt-dlp", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract info: %w", err)
+	}
+
+	var info videoInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse video info: %w", err)
+	}
+
+	// Redirects and embedded players can lead yt-dlp to other hosts,
+	// including the one the audio itself is downloaded from. The proxy
+	// would refuse them at playback anyway, but this way the track is
+	// never queued.
+	for _, u := range []string{info.WebpageURL, info.URL} {
+		if err := r.hosts.CheckURL(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+
+	// The page URL stays valid, while the media URL expires after a while
+	url := info.WebpageURL
+	if url == "" {
+		url = info.URL
+	}
+
+	return []*Track{{
+		Title:     info.Title,
+		URL:       url,
+		Duration:  info.Duration,
+		Thumbnail: info.Thumbnail,
+	}}, nil
+}
+
+// commandReader is the output of a helper process, which is killed and
+// reaped when the reader is closed.
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (cr *commandReader) Close() error {
+	cr.cmd.Process.Kill()
+	cr.ReadCloser.Close()
+	cr.cmd.Wait()
+	return nil
+}
+
+// startCommand runs a helper process and returns its output.
+func startCommand(cmd *exec.Cmd) (io.ReadCloser, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", cmd.Args[0], err)
+	}
+
+	return &commandReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (r *YTDLPResolver) Stream(ctx context.Context, track *Track) (io.ReadCloser, error) {
+	args, err := r.args()
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "--output", "-", "--", track.URL)
+
+	return startCommand(exec.CommandContext(ctx, "yt-dlp", args...))
+}
+
+func (r *YTDLPResolver) claims(track *Track) bool {
+	return !track.IsLocal && !track.IsDirect && !track.IsLive && !IsLocalPath(track.URL)
+}
@@ -0,0 +1,89 @@
+/*
+ * Miku Discord Music Bot
+ * Copyright (C) 2025 blubskye (https://github.com/blubskye)
+ * Discord: blubaustin
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ * Source: https://github.com/blubskye/miku_discord_music_bot
+ */
+
+package permissions
+
+import (
+	"sync"
+	"time"
+)
+
+// listenerIdleTimeout is how long a voice channel member can go without
+// speaking or changing their voice state before they stop counting as an
+// active listener.
+const listenerIdleTimeout = 10 * time.Minute
+
+// Activity records when members last spoke or changed their voice state,
+// per guild. Members never seen since the tracker started count as active
+// from its start, so nobody is idle right after a restart.
+type Activity struct {
+	started time.Time
+	last    map[string]map[string]time.Time // Guild ID -> user ID -> last activity
+	mu      sync.Mutex
+}
+
+func NewActivity() *Activity {
+	return &Activity{
+		started: time.Now(),
+		last:    make(map[string]map[string]time.Time),
+	}
+}
+
+// Touch marks a member as active now.
+func (a *Activity) Touch(guildID, userID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	members, ok := a.last[guildID]
+	if !ok {
+		members = make(map[string]time.Time)
+		a.last[guildID] = members
+	}
+	members[userID] = time.Now()
+}
+
+// Forget drops a member's record, e.g. once they leave voice.
+func (a *Activity) Forget(guildID, userID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.last[guildID], userID)
+	if len(a.last[guildID]) == 0 {
+		delete(a.last, guildID)
+	}
+}
+
+// Idle reports whether a member has had no activity for
+// listenerIdleTimeout. A nil tracker treats everyone as active.
+func (a *Activity) Idle(guildID, userID string) bool {
+	if a == nil {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	last, ok := a.last[guildID][userID]
+	if !ok {
+		last = a.started
+	}
+	return time.Since(last) >= listenerIdleTimeout
+}
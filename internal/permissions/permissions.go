@@ -22,6 +22,9 @@
 package permissions
 
 import (
+	"sync"
+	"time"
+
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -34,39 +37,283 @@ const (
 	LevelAdmin
 )
 
+// levelCacheTTL bounds how long a computed level is trusted when no gateway
+// event invalidates it first (e.g. the members intent is disabled).
+const levelCacheTTL = 2 * time.Minute
+
+type cachedLevel struct {
+	level   Level
+	expires time.Time
+}
+
+// Permission maps guild roles to permission levels. Any number of roles can
+// grant DJ or Mod, and optionally every role positioned at or above
+// djAboveRoleID in the guild's role hierarchy counts as DJ too.
+//
+// Computed member levels are cached until the member or the guild's roles
+// change, or until levelCacheTTL passes.
 type Permission struct {
-	djRoleID  string
-	modRoleID string
+	djRoles       map[string]bool
+	modRoles      map[string]bool
+	djAboveRoleID string
+	levels        map[string]cachedLevel
+	// ownerPrivileges lets users manage tracks they requested themselves
+	// without DJ rights.
+	ownerPrivileges bool
+	// aloneDJ grants DJ to a user who is the only active listener in the
+	// bot's voice channel.
+	aloneDJ bool
+	// memberEvents is set when the bot receives member updates, which is
+	// the only time the state cache's copy of a member's roles is current.
+	memberEvents bool
+	// activity tells aloneDJ which other listeners have gone idle.
+	activity *Activity
+	mu       sync.RWMutex
 }
 
-func New(djRoleID, modRoleID string) *Permission {
-	return &Permission{
-		djRoleID:  djRoleID,
-		modRoleID: modRoleID,
-	}
+// New creates a guild's permissions. memberEvents says whether the bot has
+// the members intent; without it members are always fetched over REST.
+// activity may be nil, in which case every listener counts as active.
+func New(djRoleIDs, modRoleIDs []string, djAboveRoleID string, memberEvents bool, activity *Activity) *Permission {
+	p := &Permission{ownerPrivileges: true, memberEvents: memberEvents, activity: activity}
+	p.UpdateRoles(djRoleIDs, modRoleIDs, djAboveRoleID)
+	return p
 }
 
 func (p *Permission) GetUserLevel(s *discordgo.Session, guildID, userID string) (Level, error) {
-	member, err := s.GuildMember(guildID, userID)
+	level, err := p.roleLevel(s, guildID, userID)
+	if err != nil {
+		return level, err
+	}
+
+	// Voice presence changes constantly, so this part is never cached
+	if level < LevelDJ && p.AloneDJ() && p.soleListener(s, guildID, userID) {
+		return LevelDJ, nil
+	}
+
+	return level, nil
+}
+
+// roleLevel returns the level granted by a member's roles and guild
+// permissions, using the cache when possible.
+func (p *Permission) roleLevel(s *discordgo.Session, guildID, userID string) (Level, error) {
+	if level, ok := p.cachedLevel(userID); ok {
+		return level, nil
+	}
+
+	member, err := p.guildMember(s, guildID, userID)
 	if err != nil {
 		return LevelUser, err
 	}
 
-	perms, err := s.UserChannelPermissions(userID, guildID)
+	var level Level
+	perms, err := guildPermissions(s, guildID, member)
 	if err == nil && (perms&discordgo.PermissionAdministrator != 0 || perms&discordgo.PermissionManageServer != 0) {
-		return LevelAdmin, nil
+		level = LevelAdmin
+	} else {
+		level = p.levelForRoles(s, guildID, member.Roles)
+	}
+
+	p.storeLevel(userID, level)
+	return level, nil
+}
+
+// guildMember fetches a member to compute their level from. The state
+// cache is only used with the members intent: without it, members cached
+// from guild creates and voice states never get their roles updated, and a
+// demoted member would keep their level until the bot restarts.
+func (p *Permission) guildMember(s *discordgo.Session, guildID, userID string) (*discordgo.Member, error) {
+	if p.memberEvents {
+		if member, err := s.State.Member(guildID, userID); err == nil {
+			return member, nil
+		}
+	}
+	return s.GuildMember(guildID, userID)
+}
+
+// soleListener reports whether userID is the only human actively listening
+// in the bot's voice channel. Bots, deafened members and members who have
+// neither spoken nor changed their voice state for listenerIdleTimeout
+// don't count as listeners.
+func (p *Permission) soleListener(s *discordgo.Session, guildID, userID string) bool {
+	guild, err := s.State.Guild(guildID)
+	if err != nil || s.State.User == nil {
+		return false
+	}
+
+	botID := s.State.User.ID
+	botChannel := ""
+	userChannel := ""
+	for _, vs := range guild.VoiceStates {
+		switch vs.UserID {
+		case botID:
+			botChannel = vs.ChannelID
+		case userID:
+			userChannel = vs.ChannelID
+		}
+	}
+
+	if botChannel == "" || userChannel != botChannel {
+		return false
+	}
+
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID != botChannel || vs.UserID == botID || vs.UserID == userID {
+			continue
+		}
+		if vs.Deaf || vs.SelfDeaf || isBotUser(s, guildID, vs) || p.activity.Idle(guildID, vs.UserID) {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+func isBotUser(s *discordgo.Session, guildID string, vs *discordgo.VoiceState) bool {
+	if vs.Member != nil && vs.Member.User != nil {
+		return vs.Member.User.Bot
 	}
+	if member, err := s.State.Member(guildID, vs.UserID); err == nil && member.User != nil {
+		return member.User.Bot
+	}
+	return false
+}
 
+func (p *Permission) cachedLevel(userID string) (Level, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	cached, ok := p.levels[userID]
+	if !ok || time.Now().After(cached.expires) {
+		return LevelUser, false
+	}
+	return cached.level, true
+}
+
+func (p *Permission) storeLevel(userID string, level Level) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.levels[userID] = cachedLevel{
+		level:   level,
+		expires: time.Now().Add(levelCacheTTL),
+	}
+}
+
+// InvalidateMember drops the cached level for one member, e.g. after their
+// roles changed.
+func (p *Permission) InvalidateMember(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.levels, userID)
+}
+
+// InvalidateAll drops every cached level in the guild, e.g. after a role was
+// edited, reordered or deleted.
+func (p *Permission) InvalidateAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.levels = make(map[string]cachedLevel)
+}
+
+// guildPermissions computes a member's guild-wide permissions from the
+// @everyone role and their own roles. Channel overwrites are deliberately
+// ignored: admin detection shouldn't depend on where a command was typed.
+func guildPermissions(s *discordgo.Session, guildID string, member *discordgo.Member) (int64, error) {
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		guild, err = s.Guild(guildID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if member.User != nil && guild.OwnerID == member.User.ID {
+		return discordgo.PermissionAll, nil
+	}
+
+	memberRoles := make(map[string]bool, len(member.Roles))
 	for _, roleID := range member.Roles {
-		if p.modRoleID != "" && roleID == p.modRoleID {
-			return LevelMod, nil
+		memberRoles[roleID] = true
+	}
+
+	var perms int64
+	for _, role := range guild.Roles {
+		// The @everyone role shares its ID with the guild
+		if role.ID == guildID || memberRoles[role.ID] {
+			perms |= role.Permissions
 		}
-		if p.djRoleID != "" && roleID == p.djRoleID {
-			return LevelDJ, nil
+	}
+
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return discordgo.PermissionAll, nil
+	}
+
+	return perms, nil
+}
+
+// levelForRoles returns the highest level granted by any of the given roles,
+// regardless of the order Discord lists them in.
+func (p *Permission) levelForRoles(s *discordgo.Session, guildID string, roleIDs []string) Level {
+	// UpdateRoles swaps the maps rather than mutating them, so they can be
+	// read without holding the lock across REST lookups.
+	p.mu.RLock()
+	djRoles, modRoles, djAboveRoleID := p.djRoles, p.modRoles, p.djAboveRoleID
+	p.mu.RUnlock()
+
+	level := LevelUser
+	for _, roleID := range roleIDs {
+		if modRoles[roleID] {
+			return LevelMod
+		}
+		if djRoles[roleID] {
+			level = LevelDJ
 		}
 	}
 
-	return LevelUser, nil
+	if level == LevelUser && djAboveRoleID != "" {
+		positions := rolePositions(s, guildID)
+		threshold, ok := positions[djAboveRoleID]
+		if !ok {
+			return level
+		}
+		for _, roleID := range roleIDs {
+			if position, ok := positions[roleID]; ok && position >= threshold {
+				return LevelDJ
+			}
+		}
+	}
+
+	return level
+}
+
+// rolePositions maps the guild's role IDs to their position in the
+// hierarchy. The state cache is used whenever it has the guild; roles
+// missing from it have been deleted, so the REST API is only asked when
+// the guild itself isn't cached.
+func rolePositions(s *discordgo.Session, guildID string) map[string]int {
+	if guild, err := s.State.Guild(guildID); err == nil {
+		s.State.RLock()
+		defer s.State.RUnlock()
+		return positionsOf(guild.Roles)
+	}
+
+	roles, err := s.GuildRoles(guildID)
+	if err != nil {
+		return nil
+	}
+	return positionsOf(roles)
+}
+
+func positionsOf(roles []*discordgo.Role) map[string]int {
+	positions := make(map[string]int, len(roles))
+	for _, role := range roles {
+		positions[role.ID] = role.Position
+	}
+	return positions
 }
 
 func (p *Permission) HasPermission(userLevel, requiredLevel Level) bool {
@@ -89,15 +336,103 @@ func (p *Permission) CanSkip(level Level) bool {
 	return level >= LevelDJ
 }
 
+// CanRemoveTrack allows DJs to remove any track and, when owner privileges
+// are enabled, users to remove tracks they requested.
+func (p *Permission) CanRemoveTrack(level Level, ownsTrack bool) bool {
+	return p.CanRemoveMusic(level) || (ownsTrack && p.OwnerPrivileges())
+}
+
+// CanSkipTrack allows DJs to skip any track and, when owner privileges are
+// enabled, users to skip the track they requested.
+func (p *Permission) CanSkipTrack(level Level, ownsTrack bool) bool {
+	return p.CanSkip(level) || (ownsTrack && p.OwnerPrivileges())
+}
+
+// CanMoveTrack allows DJs to move any track anywhere. Owners may only move
+// their own tracks further down the queue, never ahead of other people.
+func (p *Permission) CanMoveTrack(level Level, ownsTrack bool, from, to int) bool {
+	if p.CanMoveToTop(level) {
+		return true
+	}
+	return ownsTrack && p.OwnerPrivileges() && to >= from
+}
+
+func (p *Permission) OwnerPrivileges() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.ownerPrivileges
+}
+
+func (p *Permission) SetOwnerPrivileges(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ownerPrivileges = enabled
+}
+
+func (p *Permission) AloneDJ() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.aloneDJ
+}
+
+func (p *Permission) SetAloneDJ(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.aloneDJ = enabled
+}
+
+// CanManageStations allows DJs to add and remove radio favorites.
+func (p *Permission) CanManageStations(level Level) bool {
+	return level >= LevelDJ
+}
+
+// CanManagePodcasts allows DJs to subscribe to and unsubscribe from
+// podcasts.
+func (p *Permission) CanManagePodcasts(level Level) bool {
+	return level >= LevelDJ
+}
+
 func (p *Permission) CanManageQueue(level Level) bool {
 	return level >= LevelMod
 }
 
+func (p *Permission) CanBanUsers(level Level) bool {
+	return level >= LevelMod
+}
+
 func (p *Permission) CanChangeSettings(level Level) bool {
 	return level >= LevelAdmin
 }
 
-func (p *Permission) UpdateRoles(djRoleID, modRoleID string) {
-	p.djRoleID = djRoleID
-	p.modRoleID = modRoleID
+// RoleUse reports what a role is configured as: a DJ role, a Mod role, or
+// the role DJ is granted above.
+func (p *Permission) RoleUse(roleID string) (dj, mod, djAbove bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.djRoles[roleID], p.modRoles[roleID], p.djAboveRoleID == roleID
+}
+
+func (p *Permission) UpdateRoles(djRoleIDs, modRoleIDs []string, djAboveRoleID string) {
+	djRoles := make(map[string]bool, len(djRoleIDs))
+	for _, roleID := range djRoleIDs {
+		djRoles[roleID] = true
+	}
+
+	modRoles := make(map[string]bool, len(modRoleIDs))
+	for _, roleID := range modRoleIDs {
+		modRoles[roleID] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.djRoles = djRoles
+	p.modRoles = modRoles
+	p.djAboveRoleID = djAboveRoleID
+	p.levels = make(map[string]cachedLevel)
 }
@@ -22,6 +22,7 @@
 package queue
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 
@@ -29,19 +30,38 @@ import (
 	"miku_bot/internal/music"
 )
 
+// ErrQueueFull is returned by AddTrack when a guild's queue has reached the
+// configured maximum size.
+var ErrQueueFull = errors.New("queue is full")
+
 type Manager struct {
-	db      *database.Database
-	players map[string]*music.Player
-	mu      sync.RWMutex
+	db           *database.Database
+	players      map[string]*music.Player
+	mu           sync.RWMutex
+	editMu       sync.Mutex // Keeps player and database in step; every edit updates the database first
+	maxQueueSize int        // 0 means unlimited
+	resolvers    *music.Resolvers
+
+	// Speaking, if set, is called whenever someone starts speaking in a
+	// voice channel one of the players is connected to.
+	Speaking func(guildID, userID string)
 }
 
-func NewManager(db *database.Database) *Manager {
+func NewManager(db *database.Database, maxQueueSize int, resolvers *music.Resolvers) *Manager {
 	return &Manager{
-		db:      db,
-		players: make(map[string]*music.Player),
+		db:           db,
+		players:      make(map[string]*music.Player),
+		maxQueueSize: maxQueueSize,
+		resolvers:    resolvers,
 	}
 }
 
+// MaxQueueSize returns the maximum number of queued tracks per guild, or 0
+// if unlimited.
+func (m *Manager) MaxQueueSize() int {
+	return m.maxQueueSize
+}
+
 func (m *Manager) GetPlayer(guildID string) *music.Player {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -50,7 +70,12 @@ func (m *Manager) GetPlayer(guildID string) *music.Player {
 		return player
 	}
 
-	player := music.NewPlayer(guildID)
+	player := music.NewPlayer(guildID, m.resolvers)
+	if m.Speaking != nil {
+		player.OnSpeaking = func(userID string) {
+			m.Speaking(guildID, userID)
+		}
+	}
 	m.players[guildID] = player
 	return player
 }
@@ -67,6 +92,14 @@ func (m *Manager) RemovePlayer(guildID string) {
 }
 
 func (m *Manager) AddTrack(guildID, channelID, userID string, track *music.Track) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
+	player := m.GetPlayer(guildID)
+	if m.maxQueueSize > 0 && len(player.GetQueue()) >= m.maxQueueSize {
+		return fmt.Errorf("%w (%d tracks max)", ErrQueueFull, m.maxQueueSize)
+	}
+
 	dbItem := &database.QueueItem{
 		GuildID:   guildID,
 		ChannelID: channelID,
@@ -81,13 +114,63 @@ func (m *Manager) AddTrack(guildID, channelID, userID string, track *music.Track
 		return fmt.Errorf("failed to add track to database: %w", err)
 	}
 
-	player := m.GetPlayer(guildID)
 	player.AddTrack(track)
 
 	return nil
 }
 
+// QueueSpace returns how many more tracks fit in a guild's queue, or -1 if
+// the queue size is unlimited.
+func (m *Manager) QueueSpace(guildID string) int {
+	if m.maxQueueSize <= 0 {
+		return -1
+	}
+	return max(m.maxQueueSize-len(m.GetPlayer(guildID).GetQueue()), 0)
+}
+
+// AddTracks adds as many tracks as fit in the queue, saving them in one
+// transaction, and returns how many were added. It returns ErrQueueFull if
+// none fit.
+func (m *Manager) AddTracks(guildID, channelID, userID string, tracks []*music.Track) (int, error) {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
+	if space := m.QueueSpace(guildID); space >= 0 && len(tracks) > space {
+		if space == 0 {
+			return 0, fmt.Errorf("%w (%d tracks max)", ErrQueueFull, m.maxQueueSize)
+		}
+		tracks = tracks[:space]
+	}
+
+	items := make([]*database.QueueItem, 0, len(tracks))
+	for _, track := range tracks {
+		items = append(items, &database.QueueItem{
+			GuildID:   guildID,
+			ChannelID: channelID,
+			UserID:    userID,
+			Title:     track.Title,
+			URL:       track.URL,
+			Duration:  track.Duration,
+			Thumbnail: track.Thumbnail,
+		})
+	}
+
+	if err := m.db.AddToQueueBatch(items); err != nil {
+		return 0, fmt.Errorf("failed to add tracks to database: %w", err)
+	}
+
+	player := m.GetPlayer(guildID)
+	for _, track := range tracks {
+		player.AddTrack(track)
+	}
+
+	return len(tracks), nil
+}
+
 func (m *Manager) RemoveTrack(guildID string, position int) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
 	if err := m.db.RemoveFromQueue(guildID, position); err != nil {
 		return fmt.Errorf("failed to remove track from database: %w", err)
 	}
@@ -101,6 +184,9 @@ func (m *Manager) RemoveTrack(guildID string, position int) error {
 }
 
 func (m *Manager) MoveToTop(guildID string, position int) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
 	if err := m.db.MoveToTop(guildID, position); err != nil {
 		return fmt.Errorf("failed to move track in database: %w", err)
 	}
@@ -113,7 +199,95 @@ func (m *Manager) MoveToTop(guildID string, position int) error {
 	return nil
 }
 
+func (m *Manager) RemoveUserTracks(guildID, userID string) (int, error) {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
+	if err := m.db.RemoveUserTracks(guildID, userID); err != nil {
+		return 0, fmt.Errorf("failed to remove user tracks from database: %w", err)
+	}
+
+	player := m.GetPlayer(guildID)
+	return player.RemoveUserTracks(userID), nil
+}
+
+// RemoveTrackIfOwned removes the track at position only if userID requested
+// it, returning music.ErrNotOwner otherwise.
+func (m *Manager) RemoveTrackIfOwned(guildID string, position int, userID string) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
+	player := m.GetPlayer(guildID)
+	if err := checkOwner(player, position, userID); err != nil {
+		return err
+	}
+
+	if err := m.db.RemoveFromQueue(guildID, position); err != nil {
+		return fmt.Errorf("failed to remove track from database: %w", err)
+	}
+
+	if err := player.RemoveTrackIfOwned(position, userID); err != nil {
+		return fmt.Errorf("failed to remove track from player: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) MoveTrack(guildID string, from, to int) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
+	if err := m.db.MoveTrack(guildID, from, to); err != nil {
+		return fmt.Errorf("failed to move track in database: %w", err)
+	}
+
+	player := m.GetPlayer(guildID)
+	if err := player.MoveTrack(from, to); err != nil {
+		return fmt.Errorf("failed to move track in player: %w", err)
+	}
+
+	return nil
+}
+
+// MoveTrackIfOwned moves a track only if userID requested it, returning
+// music.ErrNotOwner otherwise.
+func (m *Manager) MoveTrackIfOwned(guildID string, from, to int, userID string) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
+	player := m.GetPlayer(guildID)
+	if err := checkOwner(player, from, userID); err != nil {
+		return err
+	}
+
+	if err := m.db.MoveTrack(guildID, from, to); err != nil {
+		return fmt.Errorf("failed to move track in database: %w", err)
+	}
+
+	if err := player.MoveTrackIfOwned(from, to, userID); err != nil {
+		return fmt.Errorf("failed to move track in player: %w", err)
+	}
+
+	return nil
+}
+
+// checkOwner reports whether userID requested the track at position, so the
+// IfOwned edits can refuse before touching the database.
+func checkOwner(player *music.Player, position int, userID string) error {
+	queue := player.GetQueue()
+	if position < 0 || position >= len(queue) {
+		return errors.New("invalid position")
+	}
+	if queue[position].Requester != userID {
+		return music.ErrNotOwner
+	}
+	return nil
+}
+
 func (m *Manager) ClearQueue(guildID string) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
 	if err := m.db.ClearQueue(guildID); err != nil {
 		return fmt.Errorf("failed to clear queue in database: %w", err)
 	}
@@ -125,6 +299,9 @@ func (m *Manager) ClearQueue(guildID string) error {
 }
 
 func (m *Manager) LoadQueue(guildID string) error {
+	m.editMu.Lock()
+	defer m.editMu.Unlock()
+
 	items, err := m.db.GetQueue(guildID)
 	if err != nil {
 		return fmt.Errorf("failed to load queue from database: %w", err)